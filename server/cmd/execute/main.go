@@ -12,6 +12,7 @@ import (
 	"execute/internal/handlers/scoreboard"
 	"execute/internal/handlers/task"
 	"execute/internal/handlers/user"
+	"execute/internal/mail"
 	"execute/internal/middleware"
 	"execute/internal/utils"
 )
@@ -20,49 +21,300 @@ const addr = ":8437"
 
 func main() {
 	internal.InitDB()
+	auth.InitSessionStore()
+	auth.InitSSO()
 	go auth.CleanupExpiredSessions(10 * time.Minute)
 	dataflow.InitPS()
+	mail.Init()
 
 	mux := http.NewServeMux()
 
 	// Wrap handlers with ApplyMiddlewares or ApplyAuthMidlewares!
+	// Use ApplyScopedAuthMiddlewares for routes that personal access tokens may call.
 
 	// AUTH
 	mux.Handle("/register", middleware.ApplyMiddlewares(http.HandlerFunc(auth.RegisterHandler)))
 	mux.Handle("/login", middleware.ApplyMiddlewares(http.HandlerFunc(auth.LoginHandler)))
-	mux.Handle("/validate", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.ValidateHandler)))
+	mux.Handle("/login/2fa", middleware.ApplyMiddlewares(http.HandlerFunc(auth.LoginTwoFactorHandler)))
+	mux.Handle("/validate", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(auth.ValidateHandler), middleware.Scopes{
+		"GET": auth.ScopeProfileRead,
+	}))
+	mux.Handle("/logout", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.LogoutHandler)))
+	mux.Handle("/sessions", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":    auth.ListSessionsHandler,
+		"DELETE": auth.RevokeOtherSessionsHandler,
+	})))
+	mux.Handle("/sessions/{id}", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"DELETE": auth.RevokeSessionHandler,
+	})))
+	mux.Handle("/sudo", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  auth.SudoStatusHandler,
+		"POST": auth.SudoHandler,
+	})))
+	mux.Handle("/password/forgot", middleware.ApplyMiddlewares(http.HandlerFunc(auth.ForgotPasswordHandler)))
+	mux.Handle("/password/reset", middleware.ApplyMiddlewares(http.HandlerFunc(auth.ResetPasswordHandler)))
 
-	// USER
-	mux.Handle("/user", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
-		"GET": user.UsersHandler,
-		"PUT": user.EditUserHandler,
+	// SINGLE SIGN-ON
+	mux.Handle("/sso/login", middleware.ApplyMiddlewares(http.HandlerFunc(auth.SSOLoginHandler)))
+	mux.Handle("/sso/callback", middleware.ApplyMiddlewares(http.HandlerFunc(auth.SSOCallbackHandler)))
+	mux.Handle("/sso/link", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.SSOLinkHandler)))
+	mux.Handle("/sso/identities", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.ListIdentitiesHandler)))
+	mux.Handle("/sso/identities/{id}", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.UnlinkIdentityHandler)))
+
+	mux.Handle("/admin/unlock", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.UnlockAccountHandler)))
+
+	// ACCESS TOKENS
+	mux.Handle("/tokens", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  auth.ListAccessTokensHandler,
+		"POST": auth.CreateAccessTokenHandler,
 	})))
-	mux.Handle("/avatar", middleware.ApplyAuthMiddlewares(http.HandlerFunc(user.ServeAvatarHandler)))
-	mux.Handle("/user/current", middleware.ApplyAuthMiddlewares(http.HandlerFunc(user.UserProfileHandler)))
+	mux.Handle("/tokens/{id}", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"DELETE": auth.RevokeAccessTokenHandler,
+	})))
+
+	// TWO-FACTOR
+	mux.Handle("/2fa", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.TwoFactorStatusHandler)))
+	mux.Handle("/2fa/enroll", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.EnrollTwoFactorHandler)))
+	mux.Handle("/2fa/qr", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.TwoFactorQRHandler)))
+	mux.Handle("/2fa/activate", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.ActivateTwoFactorHandler)))
+	mux.Handle("/2fa/recovery-codes", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.RegenerateRecoveryCodesHandler)))
+	mux.Handle("/2fa/disable", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.DisableTwoFactorHandler)))
+
+	// USER
+	mux.Handle("/user", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":    user.UsersHandler,
+		"PUT":    user.EditUserHandler,
+		"DELETE": user.DeleteAccountHandler,
+	}), middleware.Scopes{
+		"GET": auth.ScopeProfileRead,
+		"PUT": auth.ScopeProfileWrite,
+	}))
+	mux.Handle("/avatar", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(user.ServeAvatarHandler), middleware.Scopes{
+		"GET": auth.ScopeProfileRead,
+	}))
+	mux.Handle("/user/current", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(user.UserProfileHandler), middleware.Scopes{
+		"GET": auth.ScopeProfileRead,
+	}))
+	mux.Handle("/user/email", middleware.ApplyAuthMiddlewares(http.HandlerFunc(auth.SetEmailHandler)))
+	mux.Handle("/user/email/verify", middleware.ApplyMiddlewares(http.HandlerFunc(auth.VerifyEmailHandler)))
+	mux.Handle("/user/security", middleware.ApplyAuthMiddlewares(http.HandlerFunc(user.SecurityHandler)))
+	mux.Handle("/user/export", middleware.ApplyAuthMiddlewares(http.HandlerFunc(user.ExportHandler)))
 
 	// GROUP
-	mux.Handle("/group", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
-		"GET":  user.GroupUsersHanlder,
-		"POST": group.CreateGroupHandler,
-		"PUT":  group.UpdateGroupHandler,
-	})))
-	mux.Handle("/group/join", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.JoinGroupHandler)))
-	mux.Handle("/group/leave", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.LeaveGroupHandler)))
-	mux.Handle("/group/info", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.GetGroupInfoHandler)))
-	mux.Handle("/group/meeting", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.SetGroupMeetingHandler)))
+	mux.Handle("/group", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":    user.GroupUsersHanlder,
+		"POST":   group.CreateGroupHandler,
+		"PUT":    group.UpdateGroupHandler,
+		"DELETE": group.DeleteGroupHandler,
+	}), middleware.Scopes{
+		"GET":  auth.ScopeGroupRead,
+		"POST": auth.ScopeGroupWrite,
+		"PUT":  auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/export", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.ExportGroupHandler)))
+	mux.Handle("/group/delete-token", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.DeleteTokenHandler)))
+	mux.Handle("/group/archive", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"POST":   group.ArchiveGroupHandler,
+		"DELETE": group.ArchiveGroupHandler,
+	}), middleware.Scopes{
+		"POST":   auth.ScopeGroupAdmin,
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/join", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.JoinGroupHandler), middleware.Scopes{
+		"POST": auth.ScopeGroupWrite,
+	}))
+	mux.Handle("/group/leave", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.LeaveGroupHandler), middleware.Scopes{
+		"POST": auth.ScopeGroupWrite,
+	}))
+	mux.Handle("/group/memberships", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.MembershipsHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
+	mux.Handle("/group/active", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.SetActiveGroupHandler)))
+	mux.Handle("/group/members/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"PUT":    group.ChangeRoleHandler,
+		"DELETE": group.RemoveMemberHandler,
+	}), middleware.Scopes{
+		"PUT":    auth.ScopeGroupAdmin,
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/bans", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  group.ListBansHandler,
+		"POST": group.BanHandler,
+	}), middleware.Scopes{
+		"GET":  auth.ScopeGroupAdmin,
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/bans/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"DELETE": group.UnbanHandler,
+	}), middleware.Scopes{
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/requests", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET": group.ListJoinRequestsHandler,
+	}), middleware.Scopes{
+		"GET": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/requests/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"DELETE": group.RejectJoinRequestHandler,
+	}), middleware.Scopes{
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/requests/{id}/accept", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.AcceptJoinRequestHandler), middleware.Scopes{
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/invites", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  group.ListInvitesHandler,
+		"POST": group.CreateInviteHandler,
+	}), middleware.Scopes{
+		"GET":  auth.ScopeGroupAdmin,
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/invites/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"DELETE": group.RevokeInviteHandler,
+	}), middleware.Scopes{
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/invites/{id}/qr", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.InviteQRHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/invitations", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  group.ListGroupInvitationsHandler,
+		"POST": group.CreateInvitationHandler,
+	}), middleware.Scopes{
+		"GET":  auth.ScopeGroupAdmin,
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/invitations/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"DELETE": group.CancelInvitationHandler,
+	}), middleware.Scopes{
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/invitations", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.InboxHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
+	mux.Handle("/invitations/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"DELETE": group.DeclineInvitationHandler,
+	}), middleware.Scopes{
+		"DELETE": auth.ScopeGroupWrite,
+	}))
+	mux.Handle("/invitations/{id}/accept", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.AcceptInvitationHandler), middleware.Scopes{
+		"POST": auth.ScopeGroupWrite,
+	}))
+	mux.Handle("/group/transfer", middleware.ApplyAuthMiddlewares(http.HandlerFunc(group.TransferOwnershipHandler)))
+	mux.Handle("/group/info", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.GetGroupInfoHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
+	mux.Handle("/group/meetings", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  group.ListMeetingsHandler,
+		"POST": group.CreateMeetingHandler,
+	}), middleware.Scopes{
+		"GET":  auth.ScopeGroupRead,
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/meetings/occurrences", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.OccurrencesHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
+	mux.Handle("/group/meetings/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":    group.GetMeetingHandler,
+		"PUT":    group.UpdateMeetingHandler,
+		"DELETE": group.DeleteMeetingHandler,
+	}), middleware.Scopes{
+		"GET":    auth.ScopeGroupRead,
+		"PUT":    auth.ScopeGroupAdmin,
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/meetings/{id}/rsvp", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.RSVPHandler), middleware.Scopes{
+		"PUT": auth.ScopeGroupWrite,
+	}))
+	mux.Handle("/group/meetings/{id}/occurrence", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.OccurrenceDetailsHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
+	mux.Handle("/group/meetings/{id}/attendance", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.MarkAttendanceHandler), middleware.Scopes{
+		"PUT": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/attendance", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.AttendanceReportHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
+	mux.Handle("/group/polls", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  group.ListPollsHandler,
+		"POST": group.CreatePollHandler,
+	}), middleware.Scopes{
+		"GET":  auth.ScopeGroupRead,
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/polls/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":    group.GetPollHandler,
+		"DELETE": group.DeletePollHandler,
+	}), middleware.Scopes{
+		"GET":    auth.ScopeGroupRead,
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/polls/{id}/votes", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.VotePollHandler), middleware.Scopes{
+		"PUT": auth.ScopeGroupWrite,
+	}))
+	mux.Handle("/group/polls/{id}/close", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.ClosePollHandler), middleware.Scopes{
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/labels", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET":  group.ListLabelsHandler,
+		"POST": group.CreateLabelHandler,
+	}), middleware.Scopes{
+		"GET":  auth.ScopeGroupRead,
+		"POST": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/labels/{id}", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"PUT":    group.UpdateLabelHandler,
+		"DELETE": group.DeleteLabelHandler,
+	}), middleware.Scopes{
+		"PUT":    auth.ScopeGroupAdmin,
+		"DELETE": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/workflow", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"GET": group.GetWorkflowHandler,
+		"PUT": group.UpdateWorkflowHandler,
+	}), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+		"PUT": auth.ScopeGroupAdmin,
+	}))
+	mux.Handle("/group/meeting", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(group.SetGroupMeetingHandler), middleware.Scopes{
+		"POST": auth.ScopeGroupAdmin,
+	}))
 
 	// TASK
-	mux.Handle("/task", middleware.ApplyAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+	mux.Handle("/task", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
 		"GET":    task.ListTasksHandler,
 		"POST":   task.CreateTaskHandler,
 		"PUT":    task.UpdateTaskHandler,
 		"PATCH":  task.TaskStepHandler,
 		"DELETE": task.DeleteTaskHandler,
-	})))
-	mux.Handle("/task/completion", middleware.ApplyAuthMiddlewares(http.HandlerFunc(task.ToggleTaskCompletionHandler)))
+	}), middleware.Scopes{
+		"GET":    auth.ScopeTasksRead,
+		"POST":   auth.ScopeTasksWrite,
+		"PUT":    auth.ScopeTasksWrite,
+		"PATCH":  auth.ScopeTasksWrite,
+		"DELETE": auth.ScopeTasksWrite,
+	}))
+	mux.Handle("/task/completion", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(task.ToggleTaskCompletionHandler), middleware.Scopes{
+		"PATCH": auth.ScopeTasksWrite,
+	}))
+	mux.Handle("/task/order", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(task.ReorderTaskHandler), middleware.Scopes{
+		"PATCH": auth.ScopeTasksWrite,
+	}))
+	mux.Handle("/task/assignees", middleware.ApplyScopedAuthMiddlewares(middleware.Router(map[string]http.HandlerFunc{
+		"POST":   task.AssignTaskHandler,
+		"DELETE": task.UnassignTaskHandler,
+	}), middleware.Scopes{
+		"POST":   auth.ScopeTasksWrite,
+		"DELETE": auth.ScopeTasksWrite,
+	}))
 
 	// SCOREBOARD
-	mux.Handle("/scoreboard", middleware.ApplyAuthMiddlewares(http.HandlerFunc(scoreboard.ScoreboardHandler)))
+	mux.Handle("/scoreboard", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(scoreboard.ScoreboardHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
+	mux.Handle("/scoreboard/members", middleware.ApplyScopedAuthMiddlewares(http.HandlerFunc(scoreboard.MembersHandler), middleware.Scopes{
+		"GET": auth.ScopeGroupRead,
+	}))
 
 	// v1
 	muxWithPrefix := http.StripPrefix("/api/v1", mux)
@@ -5,6 +5,7 @@ go 1.24.3
 require (
 	cloud.google.com/go/pubsub v1.49.0
 	github.com/lib/pq v1.10.9
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/crypto v0.38.0
 	golang.org/x/time v0.11.0
 )
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"execute/internal"
+	"execute/internal/utils"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionAccountExport = "account.export"
+	ActionAccountDelete = "account.delete"
+	ActionGroupArchive  = "group.archive"
+	ActionGroupDelete   = "group.delete"
+	ActionGroupExport   = "group.export"
+)
+
+// Record writes an audit log entry for an action of userID. Failures are
+// logged, not returned, so auditing never breaks a read-only action.
+func Record(r *http.Request, userID int, action string, details map[string]any) {
+	if err := insert(internal.DB, r, userID, action, details); err != nil {
+		log.Printf("failed to write audit log entry %s for user %d: %v", action, userID, err)
+	}
+}
+
+// RecordTx writes an audit log entry inside a transaction, so the entry and
+// the change it describes are committed together
+func RecordTx(tx *sql.Tx, r *http.Request, userID int, action string, details map[string]any) error {
+	return insert(tx, r, userID, action, details)
+}
+
+// insert stores the entry. Details are kept as JSON and must not contain
+// secrets. The user ID is not a foreign key, so entries outlive deleted accounts.
+func insert(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, r *http.Request, userID int, action string, details map[string]any) error {
+	if details == nil {
+		details = map[string]any{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT INTO audit_log (user_id, action, details, ip, user_agent)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		userID, action, data, utils.GetIP(r), r.UserAgent(),
+	)
+	return err
+}
@@ -12,6 +12,44 @@ import (
 
 var DB *sql.DB
 
+// defaultWorkflow gives groups without workflow states the three columns of
+// the board, each reachable from every other. $1 = 0 means all groups.
+const defaultWorkflow = `
+    WITH states AS (
+        INSERT INTO workflow_states (group_id, name, color, position, is_done)
+        SELECT g.id, s.name, s.color, s.position, s.is_done
+          FROM groups g
+         CROSS JOIN (VALUES ('To do', '#9ca3af', 1, FALSE),
+                            ('In progress', '#3b82f6', 2, FALSE),
+                            ('Done', '#22c55e', 3, TRUE)) AS s (name, color, position, is_done)
+         WHERE ($1 = 0 OR g.id = $1)
+           AND NOT EXISTS (SELECT 1 FROM workflow_states w WHERE w.group_id = g.id)
+        RETURNING id, group_id, position
+    )
+    INSERT INTO workflow_transitions (from_state_id, to_state_id)
+    SELECT a.id, b.id
+      FROM states a
+      JOIN states b ON b.group_id = a.group_id AND b.id <> a.id`
+
+// CreateDefaultWorkflow sets up the default workflow of a new group
+func CreateDefaultWorkflow(tx *sql.Tx, groupID int) error {
+	_, err := tx.Exec(defaultWorkflow, groupID)
+	return err
+}
+
+// DeleteGroup deletes a group with everything that belongs to it
+func DeleteGroup(tx *sql.Tx, groupID int) error {
+	// Events have no foreign key to their task and would be left behind
+	if _, err := tx.Exec(
+		"DELETE FROM task_events WHERE task_id IN (SELECT id FROM tasks WHERE group_id = $1)",
+		groupID,
+	); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM groups WHERE id = $1", groupID)
+	return err
+}
+
 // IsUniqueViolation reports whether an error is a PostgreSQL unique violation.
 func IsUniqueViolation(err error) bool {
 	if pgErr, ok := err.(*pq.Error); ok {
@@ -107,13 +145,6 @@ func InitDB() {
 		log.Fatal("failed to create groups table:", err)
 	}
 
-	alterUsersGroup := `
-    ALTER TABLE users
-    ADD COLUMN IF NOT EXISTS group_id INTEGER REFERENCES groups(id) ON DELETE SET NULL;`
-	if _, err := DB.Exec(alterUsersGroup); err != nil {
-		log.Fatal("failed to alter users table to add group_id:", err)
-	}
-
 	alterUsersAvatar := `
     ALTER TABLE users
     ADD COLUMN IF NOT EXISTS avatar BYTEA;`
@@ -149,6 +180,574 @@ func InitDB() {
 		log.Fatal("failed to create event tasks table:", err)
 	}
 
+	createSessions := `
+    CREATE TABLE IF NOT EXISTS sessions (
+        token_hash  TEXT        PRIMARY KEY,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        expires_at  TIMESTAMPTZ NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
+    CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);`
+	if _, err := DB.Exec(createSessions); err != nil {
+		log.Fatal("failed to create sessions table:", err)
+	}
+
+	alterSessionsMeta := `
+    ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS id           SERIAL UNIQUE,
+    ADD COLUMN IF NOT EXISTS ip           TEXT,
+    ADD COLUMN IF NOT EXISTS user_agent   TEXT,
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();`
+	if _, err := DB.Exec(alterSessionsMeta); err != nil {
+		log.Fatal("failed to alter sessions table to add metadata columns:", err)
+	}
+
+	alterUsersTOTP := `
+    ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret    TEXT,
+    ADD COLUMN IF NOT EXISTS totp_enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS totp_last_step BIGINT  NOT NULL DEFAULT 0;`
+	if _, err := DB.Exec(alterUsersTOTP); err != nil {
+		log.Fatal("failed to alter users table to add totp columns:", err)
+	}
+
+	createRecoveryCodes := `
+    CREATE TABLE IF NOT EXISTS recovery_codes (
+        id         SERIAL      PRIMARY KEY,
+        user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        code_hash  TEXT        NOT NULL,
+        used_at    TIMESTAMPTZ,
+        UNIQUE (user_id, code_hash)
+    );`
+	if _, err := DB.Exec(createRecoveryCodes); err != nil {
+		log.Fatal("failed to create recovery codes table:", err)
+	}
+
+	createLoginChallenges := `
+    CREATE TABLE IF NOT EXISTS login_challenges (
+        token_hash  TEXT        PRIMARY KEY,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        attempts    INTEGER     NOT NULL DEFAULT 0,
+        expires_at  TIMESTAMPTZ NOT NULL
+    );`
+	if _, err := DB.Exec(createLoginChallenges); err != nil {
+		log.Fatal("failed to create login challenges table:", err)
+	}
+
+	createAccessTokens := `
+    CREATE TABLE IF NOT EXISTS access_tokens (
+        id            SERIAL      PRIMARY KEY,
+        user_id       INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        name          TEXT        NOT NULL,
+        token_hash    TEXT        NOT NULL UNIQUE,
+        scopes        TEXT[]      NOT NULL,
+        created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        expires_at    TIMESTAMPTZ NOT NULL,
+        last_used_at  TIMESTAMPTZ,
+        revoked_at    TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS access_tokens_user_id_idx ON access_tokens (user_id);`
+	if _, err := DB.Exec(createAccessTokens); err != nil {
+		log.Fatal("failed to create access tokens table:", err)
+	}
+
+	alterUsersLockout := `
+    ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS is_admin             BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS failed_login_count   INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS failed_login_total   INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS locked_until         TIMESTAMPTZ;`
+	if _, err := DB.Exec(alterUsersLockout); err != nil {
+		log.Fatal("failed to alter users table to add lockout columns:", err)
+	}
+
+	// Password hashes are self-describing PHC strings now, the salt column only
+	// holds the salt of legacy hashes
+	alterUsersSalt := `
+    ALTER TABLE users
+    ALTER COLUMN salt SET DEFAULT '';`
+	if _, err := DB.Exec(alterUsersSalt); err != nil {
+		log.Fatal("failed to alter users table to default the salt column:", err)
+	}
+
+	alterUsersEmail := `
+    ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email             TEXT,
+    ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;`
+	if _, err := DB.Exec(alterUsersEmail); err != nil {
+		log.Fatal("failed to alter users table to add email columns:", err)
+	}
+
+	// A verified address belongs to exactly one account
+	createUsersEmailIndex := `
+    CREATE UNIQUE INDEX IF NOT EXISTS users_verified_email_idx
+        ON users (LOWER(email))
+     WHERE email_verified_at IS NOT NULL;`
+	if _, err := DB.Exec(createUsersEmailIndex); err != nil {
+		log.Fatal("failed to create users email index:", err)
+	}
+
+	createUserTokens := `
+    CREATE TABLE IF NOT EXISTS user_tokens (
+        token_hash       TEXT PRIMARY KEY,
+        user_id          INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        purpose          TEXT NOT NULL,
+        email            TEXT NOT NULL,
+        created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        expires_at       TIMESTAMPTZ NOT NULL,
+        used_at          TIMESTAMPTZ
+    );
+    CREATE INDEX IF NOT EXISTS user_tokens_user_id_idx ON user_tokens (user_id, purpose);
+    CREATE INDEX IF NOT EXISTS user_tokens_expires_at_idx ON user_tokens (expires_at);`
+	if _, err := DB.Exec(createUserTokens); err != nil {
+		log.Fatal("failed to create user_tokens table:", err)
+	}
+
+	createSSOLogins := `
+    CREATE TABLE IF NOT EXISTS sso_logins (
+        state_hash       TEXT PRIMARY KEY,
+        nonce            TEXT NOT NULL,
+        code_verifier    TEXT NOT NULL,
+        link_user_id     INTEGER REFERENCES users(id) ON DELETE CASCADE,
+        redirect         TEXT NOT NULL DEFAULT '/',
+        expires_at       TIMESTAMPTZ NOT NULL
+    );`
+	if _, err := DB.Exec(createSSOLogins); err != nil {
+		log.Fatal("failed to create sso_logins table:", err)
+	}
+
+	createUserIdentities := `
+    CREATE TABLE IF NOT EXISTS user_identities (
+        id               SERIAL PRIMARY KEY,
+        issuer           TEXT NOT NULL,
+        subject          TEXT NOT NULL,
+        user_id          INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        email            TEXT,
+        created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        UNIQUE (issuer, subject)
+    );
+    CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);`
+	if _, err := DB.Exec(createUserIdentities); err != nil {
+		log.Fatal("failed to create user_identities table:", err)
+	}
+
+	alterSessionsElevation := `
+    ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS elevated_until TIMESTAMPTZ;`
+	if _, err := DB.Exec(alterSessionsElevation); err != nil {
+		log.Fatal("failed to alter sessions table to add elevated_until:", err)
+	}
+
+	// Tasks and events outlive deleted accounts without an author
+	alterTasksAnonymous := `
+    ALTER TABLE tasks
+    ALTER COLUMN creator_user_id DROP NOT NULL;
+    ALTER TABLE task_events
+    ALTER COLUMN user_id DROP NOT NULL;`
+	if _, err := DB.Exec(alterTasksAnonymous); err != nil {
+		log.Fatal("failed to alter tasks tables to allow anonymous authors:", err)
+	}
+
+	// No foreign key, entries are kept after the account is gone
+	createAuditLog := `
+    CREATE TABLE IF NOT EXISTS audit_log (
+        id          SERIAL      PRIMARY KEY,
+        user_id     INTEGER,
+        action      TEXT        NOT NULL,
+        details     JSONB       NOT NULL DEFAULT '{}',
+        ip          TEXT,
+        user_agent  TEXT,
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS audit_log_user_id_idx ON audit_log (user_id);`
+	if _, err := DB.Exec(createAuditLog); err != nil {
+		log.Fatal("failed to create audit_log table:", err)
+	}
+
+	createGroupMemberships := `
+    CREATE TABLE IF NOT EXISTS group_memberships (
+        group_id   INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        joined_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (group_id, user_id)
+    );
+    CREATE INDEX IF NOT EXISTS group_memberships_user_id_idx ON group_memberships (user_id);`
+	if _, err := DB.Exec(createGroupMemberships); err != nil {
+		log.Fatal("failed to create group_memberships table:", err)
+	}
+
+	// Users used to be in at most one group, kept in users.group_id
+	migrateUsersGroup := `
+    DO $$
+    BEGIN
+        IF EXISTS (
+            SELECT 1 FROM information_schema.columns
+             WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'group_id'
+        ) THEN
+            INSERT INTO group_memberships (group_id, user_id)
+            SELECT group_id, id FROM users WHERE group_id IS NOT NULL
+            ON CONFLICT DO NOTHING;
+            ALTER TABLE users DROP COLUMN group_id;
+        END IF;
+    END $$;`
+	if _, err := DB.Exec(migrateUsersGroup); err != nil {
+		log.Fatal("failed to migrate users.group_id to group_memberships:", err)
+	}
+
+	alterSessionsGroup := `
+    ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS active_group_id INTEGER REFERENCES groups(id) ON DELETE SET NULL;`
+	if _, err := DB.Exec(alterSessionsGroup); err != nil {
+		log.Fatal("failed to alter sessions table to add active_group_id:", err)
+	}
+
+	alterMembershipsRole := `
+    ALTER TABLE group_memberships
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member'
+        CHECK (role IN ('owner', 'admin', 'member', 'viewer'));`
+	if _, err := DB.Exec(alterMembershipsRole); err != nil {
+		log.Fatal("failed to alter group_memberships table to add role:", err)
+	}
+
+	// Every group has exactly one owner. Groups from before roles existed are
+	// owned by their creator, who is added back as a member if necessary.
+	migrateGroupOwners := `
+    INSERT INTO group_memberships (group_id, user_id, role)
+    SELECT g.id, g.creator_user_id, 'owner'
+      FROM groups g
+     WHERE NOT EXISTS (
+           SELECT 1 FROM group_memberships o
+            WHERE o.group_id = g.id AND o.role = 'owner')
+    ON CONFLICT (group_id, user_id) DO UPDATE SET role = 'owner';
+    CREATE UNIQUE INDEX IF NOT EXISTS group_memberships_owner_idx
+        ON group_memberships (group_id)
+     WHERE role = 'owner';`
+	if _, err := DB.Exec(migrateGroupOwners); err != nil {
+		log.Fatal("failed to migrate group owners:", err)
+	}
+
+	alterGroupsApproval := `
+    ALTER TABLE groups
+    ADD COLUMN IF NOT EXISTS join_approval BOOLEAN NOT NULL DEFAULT FALSE;`
+	if _, err := DB.Exec(alterGroupsApproval); err != nil {
+		log.Fatal("failed to alter groups table to add join_approval:", err)
+	}
+
+	createJoinRequests := `
+    CREATE TABLE IF NOT EXISTS join_requests (
+        id          SERIAL      PRIMARY KEY,
+        group_id    INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        UNIQUE (group_id, user_id)
+    );`
+	if _, err := DB.Exec(createJoinRequests); err != nil {
+		log.Fatal("failed to create join_requests table:", err)
+	}
+
+	createGroupBans := `
+    CREATE TABLE IF NOT EXISTS group_bans (
+        group_id    INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        banned_by   INTEGER     REFERENCES users(id) ON DELETE SET NULL,
+        reason      TEXT,
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (group_id, user_id)
+    );`
+	if _, err := DB.Exec(createGroupBans); err != nil {
+		log.Fatal("failed to create group_bans table:", err)
+	}
+
+	createInvites := `
+    CREATE TABLE IF NOT EXISTS invites (
+        id          SERIAL      PRIMARY KEY,
+        group_id    INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        code        TEXT        NOT NULL UNIQUE,
+        created_by  INTEGER     REFERENCES users(id) ON DELETE SET NULL,
+        role        TEXT        NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member', 'viewer')),
+        max_uses    INTEGER     CHECK (max_uses > 0),
+        use_count   INTEGER     NOT NULL DEFAULT 0,
+        expires_at  TIMESTAMPTZ NOT NULL,
+        revoked_at  TIMESTAMPTZ,
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS invites_group_id_idx ON invites (group_id);`
+	if _, err := DB.Exec(createInvites); err != nil {
+		log.Fatal("failed to create invites table:", err)
+	}
+
+	createInvitations := `
+    CREATE TABLE IF NOT EXISTS invitations (
+        id          SERIAL      PRIMARY KEY,
+        group_id    INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        invited_by  INTEGER     REFERENCES users(id) ON DELETE SET NULL,
+        role        TEXT        NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member', 'viewer')),
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        expires_at  TIMESTAMPTZ NOT NULL,
+        UNIQUE (group_id, user_id)
+    );
+    CREATE INDEX IF NOT EXISTS invitations_user_id_idx ON invitations (user_id);`
+	if _, err := DB.Exec(createInvitations); err != nil {
+		log.Fatal("failed to create invitations table:", err)
+	}
+
+	alterGroupsArchived := `
+    ALTER TABLE groups
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;`
+	if _, err := DB.Exec(alterGroupsArchived); err != nil {
+		log.Fatal("failed to alter groups table to add archived_at:", err)
+	}
+
+	// One pending confirmation per group, a new request replaces the old token
+	createGroupDeleteTokens := `
+    CREATE TABLE IF NOT EXISTS group_delete_tokens (
+        group_id    INTEGER     PRIMARY KEY REFERENCES groups(id) ON DELETE CASCADE,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        token_hash  TEXT        NOT NULL,
+        expires_at  TIMESTAMPTZ NOT NULL
+    );`
+	if _, err := DB.Exec(createGroupDeleteTokens); err != nil {
+		log.Fatal("failed to create group_delete_tokens table:", err)
+	}
+
+	// rrule is NULL for one-off meetings
+	createMeetings := `
+    CREATE TABLE IF NOT EXISTS meetings (
+        id                SERIAL      PRIMARY KEY,
+        group_id          INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        title             TEXT        NOT NULL,
+        starts_at         TIMESTAMPTZ NOT NULL,
+        duration_minutes  INTEGER     NOT NULL DEFAULT 60 CHECK (duration_minutes > 0),
+        timezone          TEXT        NOT NULL DEFAULT 'UTC',
+        rrule             TEXT,
+        location          TEXT,
+        link              TEXT,
+        agenda            TEXT,
+        created_by        INTEGER     REFERENCES users(id) ON DELETE SET NULL,
+        created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS meetings_group_id_idx ON meetings (group_id);
+
+    CREATE TABLE IF NOT EXISTS meeting_exceptions (
+        meeting_id  INTEGER     NOT NULL REFERENCES meetings(id) ON DELETE CASCADE,
+        occurs_at   TIMESTAMPTZ NOT NULL,
+        PRIMARY KEY (meeting_id, occurs_at)
+    );`
+	if _, err := DB.Exec(createMeetings); err != nil {
+		log.Fatal("failed to create meetings tables:", err)
+	}
+
+	createMeetingAttendance := `
+    CREATE TABLE IF NOT EXISTS meeting_rsvps (
+        meeting_id  INTEGER     NOT NULL REFERENCES meetings(id) ON DELETE CASCADE,
+        occurs_at   TIMESTAMPTZ NOT NULL,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        response    TEXT        NOT NULL CHECK (response IN ('yes', 'no', 'maybe')),
+        comment     TEXT,
+        updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (meeting_id, occurs_at, user_id)
+    );
+
+    CREATE TABLE IF NOT EXISTS meeting_attendance (
+        meeting_id  INTEGER     NOT NULL REFERENCES meetings(id) ON DELETE CASCADE,
+        occurs_at   TIMESTAMPTZ NOT NULL,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        attended    BOOLEAN     NOT NULL,
+        marked_by   INTEGER     REFERENCES users(id) ON DELETE SET NULL,
+        marked_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (meeting_id, occurs_at, user_id)
+    );
+
+    -- One bonus per occurrence at most
+    CREATE TABLE IF NOT EXISTS meeting_bonuses (
+        meeting_id  INTEGER     NOT NULL REFERENCES meetings(id) ON DELETE CASCADE,
+        occurs_at   TIMESTAMPTZ NOT NULL,
+        points      INTEGER     NOT NULL,
+        awarded_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (meeting_id, occurs_at)
+    );
+
+    ALTER TABLE groups
+    ADD COLUMN IF NOT EXISTS attendance_bonus_points    INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS attendance_bonus_threshold INTEGER NOT NULL DEFAULT 100;`
+	if _, err := DB.Exec(createMeetingAttendance); err != nil {
+		log.Fatal("failed to create meeting attendance tables:", err)
+	}
+
+	// meeting_id is set once the poll is closed and the winning slot scheduled
+	createMeetingPolls := `
+    CREATE TABLE IF NOT EXISTS meeting_polls (
+        id                SERIAL      PRIMARY KEY,
+        group_id          INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        title             TEXT        NOT NULL,
+        duration_minutes  INTEGER     NOT NULL DEFAULT 60 CHECK (duration_minutes > 0),
+        timezone          TEXT        NOT NULL DEFAULT 'UTC',
+        location          TEXT,
+        link              TEXT,
+        created_by        INTEGER     REFERENCES users(id) ON DELETE SET NULL,
+        created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        closed_at         TIMESTAMPTZ,
+        meeting_id        INTEGER     REFERENCES meetings(id) ON DELETE SET NULL
+    );
+    CREATE INDEX IF NOT EXISTS meeting_polls_group_id_idx ON meeting_polls (group_id);
+
+    CREATE TABLE IF NOT EXISTS meeting_poll_slots (
+        id         SERIAL      PRIMARY KEY,
+        poll_id    INTEGER     NOT NULL REFERENCES meeting_polls(id) ON DELETE CASCADE,
+        starts_at  TIMESTAMPTZ NOT NULL,
+        UNIQUE (poll_id, starts_at)
+    );
+
+    CREATE TABLE IF NOT EXISTS meeting_poll_votes (
+        slot_id     INTEGER     NOT NULL REFERENCES meeting_poll_slots(id) ON DELETE CASCADE,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        vote        TEXT        NOT NULL CHECK (vote IN ('available', 'if_needed', 'unavailable')),
+        updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (slot_id, user_id)
+    );`
+	if _, err := DB.Exec(createMeetingPolls); err != nil {
+		log.Fatal("failed to create meeting poll tables:", err)
+	}
+
+	// task_points is a ledger: completing a task credits its assignees,
+	// undoing it adds the opposite entries. Entries outlive deleted tasks
+	// like the group's points_score does.
+	createTaskAssignees := `
+    CREATE TABLE IF NOT EXISTS task_assignees (
+        task_id      INTEGER     NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+        user_id      INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        assigned_by  INTEGER     REFERENCES users(id) ON DELETE SET NULL,
+        assigned_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+        PRIMARY KEY (task_id, user_id)
+    );
+    CREATE INDEX IF NOT EXISTS task_assignees_user_id_idx ON task_assignees (user_id);
+
+    CREATE TABLE IF NOT EXISTS task_points (
+        id          SERIAL      PRIMARY KEY,
+        group_id    INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        task_id     INTEGER     REFERENCES tasks(id) ON DELETE SET NULL,
+        user_id     INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        points      INTEGER     NOT NULL,
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS task_points_group_id_idx ON task_points (group_id, user_id);
+    CREATE INDEX IF NOT EXISTS task_points_task_id_idx ON task_points (task_id);`
+	if _, err := DB.Exec(createTaskAssignees); err != nil {
+		log.Fatal("failed to create task assignee tables:", err)
+	}
+
+	createWorkflows := `
+    CREATE TABLE IF NOT EXISTS workflow_states (
+        id         SERIAL  PRIMARY KEY,
+        group_id   INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        name       TEXT    NOT NULL,
+        color      TEXT    NOT NULL DEFAULT '#9ca3af',
+        position   INTEGER NOT NULL,
+        wip_limit  INTEGER CHECK (wip_limit > 0),
+        is_done    BOOLEAN NOT NULL DEFAULT FALSE
+    );
+    CREATE INDEX IF NOT EXISTS workflow_states_group_id_idx ON workflow_states (group_id);
+
+    CREATE TABLE IF NOT EXISTS workflow_transitions (
+        from_state_id  INTEGER NOT NULL REFERENCES workflow_states(id) ON DELETE CASCADE,
+        to_state_id    INTEGER NOT NULL REFERENCES workflow_states(id) ON DELETE CASCADE,
+        PRIMARY KEY (from_state_id, to_state_id)
+    );
+
+    ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS state_id INTEGER REFERENCES workflow_states(id);`
+	if _, err := DB.Exec(createWorkflows); err != nil {
+		log.Fatal("failed to create workflow tables:", err)
+	}
+	if _, err := DB.Exec(defaultWorkflow, 0); err != nil {
+		log.Fatal("failed to create default workflows:", err)
+	}
+
+	// Tasks from before workflows go to the state nearest to their step,
+	// which also brings steps outside the board back into range
+	backfillTaskStates := `
+    UPDATE tasks t
+       SET state_id = (SELECT w.id FROM workflow_states w
+                        WHERE w.group_id = t.group_id
+                        ORDER BY abs(w.position - t.step), w.position
+                        LIMIT 1)
+     WHERE t.state_id IS NULL;
+
+    UPDATE tasks t
+       SET step = w.position
+      FROM workflow_states w
+     WHERE w.id = t.state_id AND t.step <> w.position;
+
+    ALTER TABLE tasks
+    ALTER COLUMN state_id SET NOT NULL;`
+	if _, err := DB.Exec(backfillTaskStates); err != nil {
+		log.Fatal("failed to move tasks into workflow states:", err)
+	}
+
+	// version changes with every edit of a task so clients can detect stale moves
+	alterTasksMoves := `
+    ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+    ALTER TABLE task_events
+    ADD COLUMN IF NOT EXISTS from_value JSONB,
+    ADD COLUMN IF NOT EXISTS to_value   JSONB;`
+	if _, err := DB.Exec(alterTasksMoves); err != nil {
+		log.Fatal("failed to alter tasks tables for moves:", err)
+	}
+
+	// rank orders the tasks within a state and sorts bytewise, hence the C
+	// collation. Backfilled ranks keep the order of creation and end in '1'
+	// because ranks must not end in '0'.
+	alterTasksRank := `
+    ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS rank TEXT COLLATE "C";
+
+    UPDATE tasks t
+       SET rank = lpad(o.n::text, 10, '0') || '1'
+      FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY state_id ORDER BY id) AS n FROM tasks) o
+     WHERE o.id = t.id AND t.rank IS NULL;
+
+    ALTER TABLE tasks
+    ALTER COLUMN rank SET NOT NULL;
+    CREATE UNIQUE INDEX IF NOT EXISTS tasks_state_rank_idx ON tasks (state_id, rank);`
+	if _, err := DB.Exec(alterTasksRank); err != nil {
+		log.Fatal("failed to add task ranks:", err)
+	}
+
+	// Due dates are optional and may be a whole day, kept as midnight UTC.
+	// Tasks saved without a due date used to get the zero time.
+	alterTasksDetails := `
+    ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'none'
+        CHECK (priority IN ('none', 'low', 'medium', 'high', 'urgent')),
+    ADD COLUMN IF NOT EXISTS due_date_only BOOLEAN NOT NULL DEFAULT FALSE,
+    ALTER COLUMN due_date DROP NOT NULL;
+
+    UPDATE tasks SET due_date = NULL WHERE due_date < '0002-01-01';
+
+    CREATE TABLE IF NOT EXISTS labels (
+        id          SERIAL      PRIMARY KEY,
+        group_id    INTEGER     NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+        name        TEXT        NOT NULL,
+        color       TEXT        NOT NULL DEFAULT '#9ca3af',
+        created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS labels_group_id_name_idx ON labels (group_id, lower(name));
+
+    CREATE TABLE IF NOT EXISTS task_labels (
+        task_id   INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+        label_id  INTEGER NOT NULL REFERENCES labels(id) ON DELETE CASCADE,
+        PRIMARY KEY (task_id, label_id)
+    );
+    CREATE INDEX IF NOT EXISTS task_labels_label_id_idx ON task_labels (label_id);`
+	if _, err := DB.Exec(alterTasksDetails); err != nil {
+		log.Fatal("failed to add task priorities and labels:", err)
+	}
+
 	// Configure the database connection pool
 	DB.SetMaxOpenConns(10)
 	DB.SetMaxIdleConns(5)
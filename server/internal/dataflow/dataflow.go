@@ -13,10 +13,12 @@ import (
 )
 
 type TaskEvent struct {
-	TaskID    int    `json:"task_id"`
-	UserID    int    `json:"user_id"`
-	EventType string `json:"event_type"`
-	Timestamp int    `json:"timestamp"`
+	TaskID    int             `json:"task_id"`
+	UserID    int             `json:"user_id"`
+	EventType string          `json:"event_type"`
+	From      json.RawMessage `json:"from,omitempty"`
+	To        json.RawMessage `json:"to,omitempty"`
+	Timestamp int             `json:"timestamp"`
 }
 
 var (
@@ -51,10 +53,32 @@ func initPubSub(projectID, topicID string) error {
 }
 
 func InsertTaskEvent(taskID, userID int, eventType string) error {
+	return InsertTaskChange(taskID, userID, eventType, nil, nil)
+}
+
+// InsertTaskChange records an event with the values before and after the
+// change, which are stored as JSON. Nil values are left out.
+func InsertTaskChange(taskID, userID int, eventType string, from, to any) error {
+	var fromJSON, toJSON json.RawMessage
+	if from != nil {
+		b, err := json.Marshal(from)
+		if err != nil {
+			return fmt.Errorf("failed to marshal task event: %w", err)
+		}
+		fromJSON = b
+	}
+	if to != nil {
+		b, err := json.Marshal(to)
+		if err != nil {
+			return fmt.Errorf("failed to marshal task event: %w", err)
+		}
+		toJSON = b
+	}
+
 	_, err := internal.DB.Exec(
-		`INSERT INTO task_events (task_id, user_id, event_type)
-		 VALUES ($1, $2, $3)`,
-		taskID, userID, eventType,
+		`INSERT INTO task_events (task_id, user_id, event_type, from_value, to_value)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		taskID, userID, eventType, nullJSON(fromJSON), nullJSON(toJSON),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert task event: %w", err)
@@ -66,6 +90,8 @@ func InsertTaskEvent(taskID, userID int, eventType string) error {
 			TaskID:    taskID,
 			UserID:    userID,
 			EventType: eventType,
+			From:      fromJSON,
+			To:        toJSON,
 			Timestamp: int(time.Now().UTC().Unix()),
 		}
 		payload, err := json.Marshal(event)
@@ -83,3 +109,11 @@ func InsertTaskEvent(taskID, userID int, eventType string) error {
 
 	return nil
 }
+
+// nullJSON stores missing values as NULL rather than an empty string
+func nullJSON(b json.RawMessage) any {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"execute/internal"
+)
+
+// Scopes that can be granted to personal access tokens
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeGroupRead    = "group:read"
+	ScopeGroupWrite   = "group:write"
+	ScopeGroupAdmin   = "group:admin"
+	ScopeTasksRead    = "tasks:read"
+	ScopeTasksWrite   = "tasks:write"
+)
+
+// AllScopes lists every scope a token may be granted
+var AllScopes = []string{
+	ScopeProfileRead,
+	ScopeProfileWrite,
+	ScopeGroupRead,
+	ScopeGroupWrite,
+	ScopeGroupAdmin,
+	ScopeTasksRead,
+	ScopeTasksWrite,
+}
+
+const (
+	accessTokenPrefix        = "exe_"
+	accessTokenDefaultDays   = 30
+	accessTokenMaxDays       = 365
+	accessTokenTouchInterval = time.Minute
+	accessTokenMaxNameLength = 100
+	accessTokenMaxPerUser    = 50
+)
+
+type AccessToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type createAccessTokenReq struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+type createAccessTokenResp struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+// generateAccessToken returns a new random token with a recognisable prefix
+func generateAccessToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return accessTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// authenticateAccessToken resolves a bearer token to the identity of its owner
+func authenticateAccessToken(token string) (Identity, error) {
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		return Identity{}, errors.New("invalid access token")
+	}
+
+	var identity Identity
+	var scopes pq.StringArray
+	var lastUsed sql.NullTime
+	err := internal.DB.QueryRow(
+		`SELECT id, user_id, scopes, last_used_at
+		   FROM access_tokens
+		  WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()`,
+		HashToken(token),
+	).Scan(&identity.TokenID, &identity.UserID, &scopes, &lastUsed)
+	if err == sql.ErrNoRows {
+		return Identity{}, errors.New("invalid or expired access token")
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	identity.Scopes = scopes
+
+	if !lastUsed.Valid || time.Since(lastUsed.Time) > accessTokenTouchInterval {
+		if _, err := internal.DB.Exec(
+			"UPDATE access_tokens SET last_used_at = NOW() WHERE id = $1", identity.TokenID,
+		); err != nil {
+			log.Printf("failed to touch access token: %v", err)
+		}
+	}
+	return identity, nil
+}
+
+// ListAccessTokensHandler handles GET /tokens
+func ListAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT id, name, scopes, created_at, expires_at, last_used_at
+		   FROM access_tokens
+		  WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		  ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to query access tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := make([]AccessToken, 0)
+	for rows.Next() {
+		var t AccessToken
+		var scopes pq.StringArray
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &scopes, &t.CreatedAt, &t.ExpiresAt, &lastUsed); err != nil {
+			http.Error(w, "Failed to scan access token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t.Scopes = scopes
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Error iterating over access tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAccessTokenHandler handles POST /tokens
+func CreateAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !RequireElevated(w, r) {
+		return
+	}
+
+	var req createAccessTokenReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > accessTokenMaxNameLength {
+		http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(AllScopes, scope) {
+			http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = accessTokenDefaultDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > accessTokenMaxDays {
+		http.Error(w, "expiresInDays must be between 1 and 365", http.StatusBadRequest)
+		return
+	}
+
+	var active int
+	if err := internal.DB.QueryRow(
+		`SELECT COUNT(*) FROM access_tokens
+		  WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`,
+		userID,
+	).Scan(&active); err != nil {
+		http.Error(w, "Failed to count access tokens: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if active >= accessTokenMaxPerUser {
+		http.Error(w, "Too many active access tokens, revoke some first", http.StatusConflict)
+		return
+	}
+
+	token, err := generateAccessToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	resp := createAccessTokenResp{Token: token}
+	resp.Name = req.Name
+	resp.Scopes = req.Scopes
+	err = internal.DB.QueryRow(
+		`INSERT INTO access_tokens (user_id, name, token_hash, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, NOW() + make_interval(days => $5))
+		 RETURNING id, created_at, expires_at`,
+		userID, req.Name, HashToken(token), pq.StringArray(req.Scopes), req.ExpiresInDays,
+	).Scan(&resp.ID, &resp.CreatedAt, &resp.ExpiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create access token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RevokeAccessTokenHandler handles DELETE /tokens/{id}
+func RevokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := internal.DB.Exec(
+		`UPDATE access_tokens SET revoked_at = NOW()
+		  WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id, userID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to revoke access token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Access token not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":      id,
+		"message": "Access token revoked",
+	})
+}
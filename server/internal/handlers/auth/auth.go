@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
@@ -61,21 +63,18 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate a unique 16-byte salt
-	saltBytes, err := GenerateSalt()
+	// Hash the password, the salt is part of the encoded hash
+	passwordHash, err := HashPassword(creds.Password)
 	if err != nil {
-		http.Error(w, "Error generating salt", http.StatusInternalServerError)
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
 	}
 
-	// Hash the password with the salt
-	passwordHash := HashPassword(creds.Password, saltBytes)
-
 	// Insert the user into the database and return the new user ID
 	var userID int
 	err = internal.DB.QueryRow(
-		"INSERT INTO users(username, salt, passwordhash) VALUES ($1, $2, $3) RETURNING id",
-		creds.Username, EncodeSalt(saltBytes), passwordHash,
+		"INSERT INTO users(username, passwordhash) VALUES ($1, $2) RETURNING id",
+		creds.Username, passwordHash,
 	).Scan(&userID)
 	if err != nil {
 		if internal.IsUniqueViolation(err) {
@@ -119,36 +118,81 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch salt and password hash from the database
+	// Fetch user ID, salt, password hash, 2FA flag and lock state from the database
+	var userID int
 	var salt, storedPasswordHash string
-	row := internal.DB.QueryRow("SELECT salt, passwordhash FROM users WHERE username = $1", creds.Username)
-	if err := row.Scan(&salt, &storedPasswordHash); err != nil {
+	var totpEnabled bool
+	var lockedUntil sql.NullTime
+	row := internal.DB.QueryRow(
+		"SELECT id, salt, passwordhash, totp_enabled, locked_until FROM users WHERE username = $1",
+		creds.Username,
+	)
+	if err := row.Scan(&userID, &salt, &storedPasswordHash, &totpEnabled, &lockedUntil); err != nil {
+		// Unknown users take as long as a wrong password
+		VerifyPassword(creds.Password, dummyHash(), "")
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	// Decode the salt and compute the password hash
-	saltBytes, err := DecodeSalt(salt)
+	// Verify the password against the stored hash
+	ok, needsRehash, err := VerifyPassword(creds.Password, storedPasswordHash, salt)
 	if err != nil {
-		http.Error(w, "Server error decoding salt", http.StatusInternalServerError)
+		http.Error(w, "Server error verifying password", http.StatusInternalServerError)
 		return
 	}
-	computedHash := HashPassword(creds.Password, saltBytes)
 
-	// Constant time comparison of hashes
-	if !CompareHashes(storedPasswordHash, computedHash) {
+	// Locked accounts are refused like a wrong password, so the response does
+	// not tell which usernames exist. Attempts during a lock are not counted.
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if !ok {
+		if _, err := recordFailedLogin(userID); err != nil {
+			log.Printf("failed to record failed login: %v", err)
+		}
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
+	// Upgrade hashes created with outdated parameters while the plain password is at hand
+	if needsRehash {
+		rehashPassword(userID, creds.Password, storedPasswordHash)
+	}
+
+	// Accounts with two-factor authentication get a pending token instead of a session
+	if totpEnabled {
+		pendingToken, err := createLoginChallenge(userID)
+		if err != nil {
+			http.Error(w, "Failed to create login challenge", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(TwoFactorRequiredResponse{
+			TwoFactorRequired: true,
+			PendingToken:      pendingToken,
+		})
+		return
+	}
+
+	if err := resetFailedLogins(userID); err != nil {
+		log.Printf("failed to reset failed logins: %v", err)
+	}
+
 	// Generate session and token
-	token, err := CreateSession(creds.Username)
+	token, err := CreateSession(userID, r)
 	if err != nil {
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
+	setSessionCookie(w, token)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TokenResponse{Token: token})
+}
 
-	// Set the session cookie
+// setSessionCookie sets the session cookie for a freshly created session
+func setSessionCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:    "session_token",
 		Value:   token,
@@ -158,7 +202,4 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		// Secure:   true,
 		SameSite: http.SameSiteStrictMode,
 	})
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(TokenResponse{Token: token})
 }
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+
+	"execute/internal"
+	"execute/internal/mail"
+)
+
+type setEmailReq struct {
+	Email string `json:"email"`
+}
+
+type verifyEmailReq struct {
+	Token string `json:"token"`
+}
+
+// SetEmailHandler handles PUT /user/email
+func SetEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req setEmailReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// An empty address removes the email from the account
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		if _, err := internal.DB.Exec(
+			"UPDATE users SET email = NULL, email_verified_at = NULL, updated_at = NOW() WHERE id = $1",
+			principal.UserID,
+		); err != nil {
+			http.Error(w, "Failed to remove email: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(Response{Message: "Email removed"})
+		return
+	}
+
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	// A new address is unverified until the link sent to it is opened
+	if _, err := internal.DB.Exec(
+		"UPDATE users SET email = $1, email_verified_at = NULL, updated_at = NOW() WHERE id = $2",
+		email, principal.UserID,
+	); err != nil {
+		http.Error(w, "Failed to update email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := createUserToken(principal.UserID, tokenPurposeEmailVerify, email, emailVerifyTokenDuration)
+	if err != nil {
+		http.Error(w, "Failed to create verification token", http.StatusInternalServerError)
+		return
+	}
+	err = mail.Send(mail.Message{
+		To:      email,
+		Subject: "Verify your email for Execute",
+		Body: "Hi " + principal.Username + ",\n\n" +
+			"please confirm that this address belongs to your Execute account by opening the link below. " +
+			"It is valid for 48 hours.\n\n" +
+			mail.AppURL("/verify-email?token="+url.QueryEscape(token)) + "\n",
+	})
+	if err != nil {
+		log.Printf("failed to send verification mail: %v", err)
+		http.Error(w, "Email saved but the verification mail could not be sent", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(Response{Message: "Verification email sent"})
+}
+
+// VerifyEmailHandler handles POST /user/email/verify
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req verifyEmailReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	userID, email, err := consumeUserToken(tx, req.Token, tokenPurposeEmailVerify)
+	if err == errInvalidUserToken {
+		http.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to check verification token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The token only verifies the address it was sent to
+	result, err := tx.Exec(
+		"UPDATE users SET email_verified_at = NOW() WHERE id = $1 AND email = $2",
+		userID, email,
+	)
+	if err != nil {
+		if internal.IsUniqueViolation(err) {
+			http.Error(w, "Email is already in use by another account", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to verify email: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "The account's email has changed since this link was sent", http.StatusBadRequest)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Email verified"})
+}
@@ -1,37 +1,70 @@
 package auth
 
 import (
-	"database/sql"
 	"errors"
 	"net/http"
-
-	"execute/internal"
+	"slices"
+	"strings"
+	"time"
 )
 
-// getUserID reads the session cookie, validates it, looks up the username
-// then looks up that user’s ID in the database
-func GetUserID(r *http.Request) (int, error) {
+// Identity describes who made a request and how they authenticated
+type Identity struct {
+	UserID    int
+	SessionID int      // Set when authenticated with the session cookie
+	TokenID   int      // Set when authenticated with a personal access token
+	Scopes    []string // Scopes granted to the access token
+	// ElevatedUntil is the end of the session's elevated window, see Elevated
+	ElevatedUntil time.Time
+	// ActiveGroupID is the group selected for the session, see Principal.Group
+	ActiveGroupID int
+}
+
+// HasScope reports whether the identity may act within the given scope.
+// Browser sessions are not scoped and may do everything.
+func (i Identity) HasScope(scope string) bool {
+	if i.TokenID == 0 {
+		return true
+	}
+	return slices.Contains(i.Scopes, scope)
+}
+
+// Authenticate resolves the identity of a request from an "Authorization: Bearer"
+// personal access token or, if no Authorization header is present, the session cookie
+func Authenticate(r *http.Request) (Identity, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return Identity{}, errors.New("unsupported authorization scheme")
+		}
+		return authenticateAccessToken(strings.TrimSpace(token))
+	}
+
 	cookie, err := r.Cookie("session_token")
 	if err != nil {
 		if err == http.ErrNoCookie {
-			return 0, errors.New("no session token found")
+			return Identity{}, errors.New("no session token found")
 		}
-		return 0, err
+		return Identity{}, err
 	}
-	token := cookie.Value
 
-	username, exists := GetSessionUsername(token)
+	session, exists := GetSession(cookie.Value)
 	if !exists {
-		return 0, errors.New("invalid or expired session token")
+		return Identity{}, errors.New("invalid or expired session token")
 	}
+	return Identity{
+		UserID:        session.UserID,
+		SessionID:     session.ID,
+		ElevatedUntil: session.ElevatedUntil,
+		ActiveGroupID: session.ActiveGroupID,
+	}, nil
+}
 
-	var userID int
-	err = internal.DB.QueryRow(
-		"SELECT id FROM users WHERE username = $1",
-		username,
-	).Scan(&userID)
-	if err == sql.ErrNoRows {
-		return 0, errors.New("user not found")
+// GetUserID returns the ID of the user the request belongs to
+func GetUserID(r *http.Request) (int, error) {
+	p, err := GetPrincipal(r)
+	if err != nil {
+		return 0, err
 	}
-	return userID, err
+	return p.UserID, nil
 }
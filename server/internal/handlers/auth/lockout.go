@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"execute/internal"
+)
+
+// LockoutConfig controls per-account brute-force protection
+type LockoutConfig struct {
+	Threshold   int           // Failed attempts before the account is locked
+	BaseLockout time.Duration // Lock duration at the threshold, doubled for every further failure
+	MaxLockout  time.Duration // Upper bound for the lock duration
+	ResetAfter  time.Duration // Failure streaks older than this are forgotten
+}
+
+var lockout = loadLockoutConfig()
+
+// loadLockoutConfig reads the lockout thresholds from the environment
+func loadLockoutConfig() LockoutConfig {
+	cfg := LockoutConfig{
+		Threshold:   5,
+		BaseLockout: time.Minute,
+		MaxLockout:  time.Hour,
+		ResetAfter:  24 * time.Hour,
+	}
+	if value := os.Getenv("LOGIN_LOCKOUT_THRESHOLD"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			cfg.Threshold = n
+		} else {
+			log.Printf("invalid LOGIN_LOCKOUT_THRESHOLD %q, using %d", value, cfg.Threshold)
+		}
+	}
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"LOGIN_LOCKOUT_BASE", &cfg.BaseLockout},
+		{"LOGIN_LOCKOUT_MAX", &cfg.MaxLockout},
+		{"LOGIN_LOCKOUT_RESET", &cfg.ResetAfter},
+	}
+	for _, d := range durations {
+		value := os.Getenv(d.env)
+		if value == "" {
+			continue
+		}
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			*d.dst = parsed
+		} else {
+			log.Printf("invalid %s %q, using %s", d.env, value, *d.dst)
+		}
+	}
+	return cfg
+}
+
+// lockDuration returns how long an account is locked after the given number of consecutive failures
+func (c LockoutConfig) lockDuration(failures int) time.Duration {
+	if failures < c.Threshold {
+		return 0
+	}
+	exp := failures - c.Threshold
+	if exp > 30 {
+		return c.MaxLockout
+	}
+	d := time.Duration(float64(c.BaseLockout) * math.Pow(2, float64(exp)))
+	if d > c.MaxLockout {
+		return c.MaxLockout
+	}
+	return d
+}
+
+// accountLockedUntil returns the end of an active lock, or the zero time if the account is not locked
+func accountLockedUntil(userID int) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := internal.DB.QueryRow(
+		"SELECT locked_until FROM users WHERE id = $1", userID,
+	).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		return lockedUntil.Time, nil
+	}
+	return time.Time{}, nil
+}
+
+// recordFailedLogin counts a failed attempt and locks the account once the threshold is reached.
+// It returns the end of the resulting lock, if any.
+func recordFailedLogin(userID int) (time.Time, error) {
+	var failures int
+	err := internal.DB.QueryRow(
+		`UPDATE users
+		    SET failed_login_count = CASE
+		            WHEN last_failed_login_at IS NULL OR last_failed_login_at < NOW() - make_interval(secs => $2)
+		            THEN 1
+		            ELSE failed_login_count + 1
+		        END,
+		        failed_login_total = failed_login_total + 1,
+		        last_failed_login_at = NOW()
+		  WHERE id = $1
+		  RETURNING failed_login_count`,
+		userID, lockout.ResetAfter.Seconds(),
+	).Scan(&failures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	d := lockout.lockDuration(failures)
+	if d == 0 {
+		return time.Time{}, nil
+	}
+	lockedUntil := time.Now().Add(d)
+	if _, err := internal.DB.Exec(
+		"UPDATE users SET locked_until = $1 WHERE id = $2", lockedUntil, userID,
+	); err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil, nil
+}
+
+// resetFailedLogins clears the failure streak after a successful login
+func resetFailedLogins(userID int) error {
+	_, err := internal.DB.Exec(
+		`UPDATE users SET failed_login_count = 0, locked_until = NULL
+		  WHERE id = $1 AND (failed_login_count <> 0 OR locked_until IS NOT NULL)`,
+		userID,
+	)
+	return err
+}
+
+// writeLocked responds that the account is locked until the given time
+func writeLocked(w http.ResponseWriter, until time.Time) {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "Account temporarily locked after too many failed attempts, try again in "+
+		(time.Duration(retryAfter)*time.Second).String(), http.StatusTooManyRequests)
+}
+
+type unlockReq struct {
+	Username string `json:"username"`
+}
+
+// UnlockAccountHandler handles POST /admin/unlock
+func UnlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !principal.IsAdmin {
+		http.Error(w, "Forbidden: administrators only", http.StatusForbidden)
+		return
+	}
+
+	var req unlockReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := internal.DB.Exec(
+		"UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE username = $1",
+		req.Username,
+	)
+	if err != nil {
+		http.Error(w, "Failed to unlock account: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Account unlocked"})
+}
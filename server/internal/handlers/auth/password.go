@@ -2,32 +2,151 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
-	"golang.org/x/crypto/argon2"
+	"errors"
+	"fmt"
 	"io"
-)
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 
-const (
-	argonTime    = 1         // Iterations
-	argonMemory  = 64 * 1024 // 64 MB in KiB
-	argonThreads = 4         // Parallelism
-	argonKeyLen  = 32        // Hash length
-	saltLen      = 16        // Salt length
+	"golang.org/x/crypto/argon2"
+
+	"execute/internal"
 )
 
+// ArgonParams are the argon2id cost parameters of a password hash
+type ArgonParams struct {
+	Memory  uint32 // KiB
+	Time    uint32 // Iterations
+	Threads uint8  // Parallelism
+	KeyLen  uint32 // Hash length
+}
+
+const saltLen = 16 // Salt length
+
+// legacyArgon are the parameters of hashes stored before the PHC format, which
+// keep their salt in the separate salt column
+var legacyArgon = ArgonParams{Memory: 64 * 1024, Time: 1, Threads: 4, KeyLen: 32}
+
+// argon are the parameters new hashes are created with. Hashes with other
+// parameters are upgraded on the next successful login.
+var argon = loadArgonParams()
+
+// loadArgonParams reads the argon2id cost parameters from the environment
+func loadArgonParams() ArgonParams {
+	p := ArgonParams{Memory: 64 * 1024, Time: 3, Threads: 4, KeyLen: 32}
+	p.Memory = uint32(envUint("ARGON2_MEMORY", uint64(p.Memory), 32))
+	p.Time = uint32(envUint("ARGON2_TIME", uint64(p.Time), 32))
+	p.Threads = uint8(envUint("ARGON2_THREADS", uint64(p.Threads), 8))
+	return p
+}
+
+// envUint reads a positive integer of the given bit size from the environment
+func envUint(env string, fallback uint64, bits int) uint64 {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.ParseUint(value, 10, bits)
+	if err != nil || n == 0 {
+		log.Printf("invalid %s %q, using %d", env, value, fallback)
+		return fallback
+	}
+	return n
+}
+
 func GenerateSalt() ([]byte, error) {
 	salt := make([]byte, saltLen)
 	_, err := io.ReadFull(rand.Reader, salt)
 	return salt, err
 }
 
-func HashPassword(password string, salt []byte) string {
-	hash := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
-	return base64.StdEncoding.EncodeToString(hash)
+// HashPassword hashes a password with a fresh salt and the current parameters.
+// The result is a self-describing PHC string:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(password), salt, argon.Time, argon.Memory, argon.Threads, argon.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon.Memory, argon.Time, argon.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// parsePHC splits a PHC encoded argon2id hash into its parameters, salt and hash
+func parsePHC(encoded string) (ArgonParams, []byte, []byte, error) {
+	var p ArgonParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errors.New("unsupported password hash format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, err
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	p.KeyLen = uint32(len(hash))
+	return p, salt, hash, nil
 }
 
-func EncodeSalt(salt []byte) string {
-	return base64.StdEncoding.EncodeToString(salt)
+// dummyHash is checked instead of a stored hash when there is none, so that
+// logins of unknown users take as long as those with a wrong password
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := HashPassword("not the password of any account")
+	if err != nil {
+		log.Printf("failed to create dummy password hash: %v", err)
+	}
+	return hash
+})
+
+// VerifyPassword checks a password against a stored hash. Hashes without the
+// PHC prefix are legacy hashes whose salt is kept in the salt column.
+// needsRehash reports whether the hash should be replaced by one with the
+// current parameters.
+func VerifyPassword(password, storedHash, legacySalt string) (ok, needsRehash bool, err error) {
+	// Accounts created through single sign-on have no password
+	if storedHash == "" {
+		return false, false, nil
+	}
+	if !strings.HasPrefix(storedHash, "$") {
+		salt, err := DecodeSalt(legacySalt)
+		if err != nil {
+			return false, false, err
+		}
+		computed := argon2.IDKey([]byte(password), salt, legacyArgon.Time, legacyArgon.Memory, legacyArgon.Threads, legacyArgon.KeyLen)
+		return CompareHashes(storedHash, base64.StdEncoding.EncodeToString(computed)), true, nil
+	}
+
+	p, salt, hash, err := parsePHC(storedHash)
+	if err != nil {
+		return false, false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(hash, computed) != 1 {
+		return false, false, nil
+	}
+	return true, p != argon, nil
 }
 
 func DecodeSalt(encoded string) ([]byte, error) {
@@ -44,3 +163,39 @@ func CompareHashes(a, b string) bool {
 	}
 	return result == 0
 }
+
+// CheckUserPassword checks a password against the stored hash of the given user
+// and upgrades outdated hashes on success
+func CheckUserPassword(userID int, password string) (bool, error) {
+	var salt, storedPasswordHash string
+	if err := internal.DB.QueryRow(
+		"SELECT salt, passwordhash FROM users WHERE id = $1", userID,
+	).Scan(&salt, &storedPasswordHash); err != nil {
+		return false, err
+	}
+	ok, needsRehash, err := VerifyPassword(password, storedPasswordHash, salt)
+	if err != nil || !ok {
+		return false, err
+	}
+	if needsRehash {
+		rehashPassword(userID, password, storedPasswordHash)
+	}
+	return true, nil
+}
+
+// rehashPassword replaces an outdated hash after the password was verified.
+// Failures are only logged since the old hash still works.
+func rehashPassword(userID int, password, oldHash string) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		log.Printf("failed to rehash password of user %d: %v", userID, err)
+		return
+	}
+	// Only replace the hash that was verified, a concurrent password change wins
+	if _, err := internal.DB.Exec(
+		"UPDATE users SET passwordhash = $1, salt = '' WHERE id = $2 AND passwordhash = $3",
+		hash, userID, oldHash,
+	); err != nil {
+		log.Printf("failed to rehash password of user %d: %v", userID, err)
+	}
+}
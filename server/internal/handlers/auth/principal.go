@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"execute/internal"
+)
+
+// ErrNoGroup is returned when the authenticated user is not in a group
+var ErrNoGroup = errors.New("no group associated with user")
+
+// ErrNotMember is returned when a request selects a group the user does not belong to
+var ErrNotMember = errors.New("not a member of the selected group")
+
+// Principal is the authenticated user of a request. It is resolved once by the
+// auth middleware and handed to handlers through the request context.
+type Principal struct {
+	Identity
+	Username string
+	Groups   []int // IDs of all groups the user is a member of, oldest membership first
+	GroupID  int   // Group the request acts on, 0 when none could be selected
+	Role     string
+	IsAdmin  bool // Platform administrator, e.g. allowed to unlock accounts
+
+	roles    map[int]Role // Role per group, see RoleIn
+	groupErr error        // Why GroupID is 0 even though a group was requested
+}
+
+// Group returns the ID of the group the request acts on. That is the group
+// given by the groupId query parameter or the X-Group-ID header, else the
+// active group of the session, else the user's oldest membership.
+func (p *Principal) Group() (int, error) {
+	if p.groupErr != nil {
+		return 0, p.groupErr
+	}
+	if p.GroupID == 0 {
+		return 0, ErrNoGroup
+	}
+	return p.GroupID, nil
+}
+
+// IsMember reports whether the user belongs to the group
+func (p *Principal) IsMember(groupID int) bool {
+	return slices.Contains(p.Groups, groupID)
+}
+
+// selectGroup resolves the group the request acts on, see Group
+func (p *Principal) selectGroup(r *http.Request) {
+	value := r.URL.Query().Get("groupId")
+	if value == "" {
+		value = r.Header.Get("X-Group-ID")
+	}
+	if value != "" {
+		requested, err := strconv.Atoi(value)
+		if err != nil || requested <= 0 {
+			p.groupErr = errors.New("invalid group ID")
+			return
+		}
+		if !p.IsMember(requested) {
+			p.groupErr = ErrNotMember
+			return
+		}
+		p.GroupID = requested
+		return
+	}
+
+	// The session's choice is ignored once the user left that group
+	if p.IsMember(p.ActiveGroupID) {
+		p.GroupID = p.ActiveGroupID
+	} else if len(p.Groups) > 0 {
+		p.GroupID = p.Groups[0]
+	}
+}
+
+// SetActiveGroup makes groupID the group later requests of the session act on
+// when they do not select one explicitly. The caller checks the membership.
+func SetActiveGroup(r *http.Request, groupID int) error {
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		return err
+	}
+	if principal.SessionID == 0 {
+		return ErrNotSession
+	}
+	if err := store.SetActiveGroup(principal.SessionID, groupID); err != nil {
+		return err
+	}
+	principal.ActiveGroupID = groupID
+	return nil
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored by the auth middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// GetPrincipal returns the principal of the request, resolving it if the auth
+// middleware has not already done so
+func GetPrincipal(r *http.Request) (*Principal, error) {
+	if p, ok := PrincipalFromContext(r.Context()); ok {
+		return p, nil
+	}
+	return ResolvePrincipal(r)
+}
+
+// ResolvePrincipal authenticates the request and loads the user it belongs to
+func ResolvePrincipal(r *http.Request) (*Principal, error) {
+	identity, err := Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	user, err := principals.get(identity.UserID)
+	if err != nil {
+		return nil, err
+	}
+	user.Identity = identity
+	user.selectGroup(r)
+	return &user, nil
+}
+
+// InvalidatePrincipal drops the cached user data so the next request reloads it.
+// Call it after changing a user's group membership, group role, username or role.
+func InvalidatePrincipal(userID int) {
+	principals.invalidate(userID)
+}
+
+// principalCache keeps recently loaded users for a short time to save a
+// database round trip per request. Entries are per process, so the TTL bounds
+// how long other replicas may serve stale data.
+type principalCache struct {
+	entries map[int]principalCacheEntry
+	ttl     time.Duration
+	mu      sync.Mutex
+}
+
+type principalCacheEntry struct {
+	principal Principal
+	expiresAt time.Time
+}
+
+var principals = newPrincipalCache()
+
+func newPrincipalCache() *principalCache {
+	ttl := 30 * time.Second
+	if value := os.Getenv("PRINCIPAL_CACHE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("invalid PRINCIPAL_CACHE_TTL %q, using %s: %v", value, ttl, err)
+		} else {
+			ttl = parsed
+		}
+	}
+	return &principalCache{
+		entries: make(map[int]principalCacheEntry),
+		ttl:     ttl,
+	}
+}
+
+func (c *principalCache) get(userID int) (Principal, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.principal, nil
+	}
+
+	p, err := loadPrincipal(userID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if c.ttl > 0 {
+		c.mu.Lock()
+		// Opportunistically drop expired entries so the map does not grow unbounded
+		for id, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.entries[userID] = principalCacheEntry{principal: p, expiresAt: now.Add(c.ttl)}
+		c.mu.Unlock()
+	}
+	return p, nil
+}
+
+func (c *principalCache) invalidate(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, userID)
+}
+
+// loadPrincipal reads the user data of a principal from the database
+func loadPrincipal(userID int) (Principal, error) {
+	var username string
+	var role sql.NullString
+	var isAdmin bool
+	err := internal.DB.QueryRow(
+		"SELECT username, role, is_admin FROM users WHERE id = $1", userID,
+	).Scan(&username, &role, &isAdmin)
+	if err == sql.ErrNoRows {
+		return Principal{}, errors.New("user not found")
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT group_id, role FROM group_memberships
+		  WHERE user_id = $1
+		  ORDER BY joined_at, group_id`,
+		userID,
+	)
+	if err != nil {
+		return Principal{}, err
+	}
+	defer rows.Close()
+	var groups []int
+	roles := make(map[int]Role)
+	for rows.Next() {
+		var groupID int
+		var role Role
+		if err := rows.Scan(&groupID, &role); err != nil {
+			return Principal{}, err
+		}
+		groups = append(groups, groupID)
+		roles[groupID] = role
+	}
+	if err := rows.Err(); err != nil {
+		return Principal{}, err
+	}
+
+	return Principal{
+		Identity: Identity{UserID: userID},
+		Username: username,
+		Groups:   groups,
+		roles:    roles,
+		Role:     role.String,
+		IsAdmin:  isAdmin,
+	}, nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"execute/internal"
+	"execute/internal/mail"
+)
+
+type forgotPasswordReq struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordReq struct {
+	Token      string `json:"token"`
+	Password   string `json:"password"`
+	RePassword string `json:"repassword"`
+}
+
+// ForgotPasswordHandler handles POST /password/forgot
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req forgotPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// The response is the same whether or not the address is known, so this
+	// endpoint cannot be used to find out which addresses have an account
+	var userID int
+	var username, address string
+	err := internal.DB.QueryRow(
+		`SELECT id, username, email FROM users
+		  WHERE LOWER(email) = LOWER($1) AND email_verified_at IS NOT NULL`,
+		email,
+	).Scan(&userID, &username, &address)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Failed to look up account: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		token, err := createUserToken(userID, tokenPurposePasswordReset, address, passwordResetTokenDuration)
+		if err != nil {
+			http.Error(w, "Failed to create reset token", http.StatusInternalServerError)
+			return
+		}
+		// Send in the background so the response time does not reveal the account either
+		go func() {
+			err := mail.Send(mail.Message{
+				To:      address,
+				Subject: "Reset your Execute password",
+				Body: "Hi " + username + ",\n\n" +
+					"someone asked to reset the password of your Execute account. " +
+					"Open the link below to choose a new one. It is valid for one hour and can be used once.\n\n" +
+					mail.AppURL("/reset-password?token="+url.QueryEscape(token)) + "\n\n" +
+					"If you did not ask for this, you can ignore this email.\n",
+			})
+			if err != nil {
+				log.Printf("failed to send password reset mail: %v", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(Response{Message: "If the address belongs to a verified account, a reset link has been sent"})
+}
+
+// ResetPasswordHandler handles POST /password/reset
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req resetPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < 8 {
+		http.Error(w, "Password must be at least 8 characters long", http.StatusBadRequest)
+		return
+	}
+	if req.Password != req.RePassword {
+		http.Error(w, "Passwords aren't the same", http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	userID, email, err := consumeUserToken(tx, req.Token, tokenPurposePasswordReset)
+	if err == errInvalidUserToken {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to check reset token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Proving access to the mailbox also lifts a login lock. The token is void
+	// if the account's email changed since it was sent.
+	result, err := tx.Exec(
+		`UPDATE users
+		    SET passwordhash = $1, salt = '', failed_login_count = 0, locked_until = NULL, updated_at = NOW()
+		  WHERE id = $2 AND email = $3 AND email_verified_at IS NOT NULL`,
+		passwordHash, userID, email,
+	)
+	if err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	// Tokens created by whoever knew the old password go with it
+	if _, err := tx.Exec(
+		"UPDATE access_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL",
+		userID,
+	); err != nil {
+		http.Error(w, "Failed to revoke access tokens", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if err := RevokeAllSessions(userID); err != nil {
+		log.Printf("failed to revoke sessions after password reset: %v", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Password has been reset, please log in"})
+}
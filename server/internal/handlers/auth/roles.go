@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Role is a user's role within one group
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+	RoleViewer Role = "viewer"
+)
+
+// Permission is something a role allows within its group
+type Permission int
+
+const (
+	PermWriteTasks        Permission = iota // Create tasks, edit and delete own tasks, move and complete tasks
+	PermEditAnyTask                         // Edit and delete tasks of other members
+	PermEditGroup                           // Rename the group and configure its workflow and labels
+	PermChangeCode                          // Set a new join code
+	PermScheduleMeetings                    // Set the meeting time
+	PermManageMembers                       // Change the roles of lower ranked members
+	PermTransferOwnership                   // Hand the group to another member
+	PermDeleteGroup                         // Archive or delete the group
+)
+
+// rolePermissions is the permission matrix. Viewers may only read.
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermWriteTasks, PermEditAnyTask, PermEditGroup, PermChangeCode,
+		PermScheduleMeetings, PermManageMembers, PermTransferOwnership, PermDeleteGroup,
+	},
+	RoleAdmin: {
+		PermWriteTasks, PermEditAnyTask, PermEditGroup, PermChangeCode,
+		PermScheduleMeetings, PermManageMembers,
+	},
+	RoleMember: {PermWriteTasks},
+	RoleViewer: {},
+}
+
+// roleRanks orders roles, a role can only manage roles ranked below it
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleMember: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+// ParseRole validates a role name
+func ParseRole(name string) (Role, bool) {
+	role := Role(name)
+	_, ok := roleRanks[role]
+	return role, ok
+}
+
+// Has reports whether the role grants the permission
+func (r Role) Has(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+// Outranks reports whether r is ranked above other
+func (r Role) Outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+// RoleIn returns the principal's role in the group, or "" if not a member
+func (p *Principal) RoleIn(groupID int) Role {
+	return p.roles[groupID]
+}
+
+// Can reports whether the principal's role in the group grants the permission
+func (p *Principal) Can(groupID int, perm Permission) bool {
+	return p.RoleIn(groupID).Has(perm)
+}
+
+// RequirePermission writes a 403 response and returns false unless the
+// principal's role in the group grants the permission
+func RequirePermission(w http.ResponseWriter, p *Principal, groupID int, perm Permission) bool {
+	if !p.IsMember(groupID) {
+		http.Error(w, ErrNotMember.Error(), http.StatusForbidden)
+		return false
+	}
+	if !p.Can(groupID, perm) {
+		http.Error(w, "Your role in this group does not allow this", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore keeps sessions in a process-local map.
+// Sessions are lost on restart and are not shared between replicas.
+type MemorySessionStore struct {
+	sessions map[string]Session
+	nextID   int
+	mu       sync.RWMutex
+}
+
+// NewMemorySessionStore creates an empty in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]Session),
+	}
+}
+
+func (s *MemorySessionStore) Create(tokenHash string, session Session) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	session.ID = s.nextID
+	s.sessions[tokenHash] = session
+	return session.ID, nil
+}
+
+func (s *MemorySessionStore) Get(tokenHash string) (Session, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, exists := s.sessions[tokenHash]
+	return session, exists, nil
+}
+
+func (s *MemorySessionStore) Touch(tokenHash string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, exists := s.sessions[tokenHash]; exists {
+		session.LastSeenAt = at
+		s.sessions[tokenHash] = session
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) Elevate(id int, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tokenHash, session := range s.sessions {
+		if session.ID == id {
+			session.ElevatedUntil = until
+			s.sessions[tokenHash] = session
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) SetActiveGroup(id, groupID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tokenHash, session := range s.sessions {
+		if session.ID == id {
+			session.ActiveGroupID = groupID
+			s.sessions[tokenHash] = session
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) ListByUser(userID int) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sessions []Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) Delete(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteByID(userID, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tokenHash, session := range s.sessions {
+		if session.UserID == userID && session.ID == id {
+			delete(s.sessions, tokenHash)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemorySessionStore) DeleteByUser(userID, exceptID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for tokenHash, session := range s.sessions {
+		if session.UserID == userID && session.ID != exceptID {
+			delete(s.sessions, tokenHash)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemorySessionStore) Cleanup(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tokenHash, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, tokenHash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+
+	"execute/internal"
+)
+
+// PostgresSessionStore keeps sessions in the sessions table so they survive
+// restarts and are shared between replicas. Only token hashes are stored.
+type PostgresSessionStore struct{}
+
+// NewPostgresSessionStore creates a session store backed by internal.DB
+func NewPostgresSessionStore() *PostgresSessionStore {
+	return &PostgresSessionStore{}
+}
+
+const sessionColumns = `id, user_id, COALESCE(ip, ''), COALESCE(user_agent, ''), created_at, last_seen_at, expires_at, elevated_until, COALESCE(active_group_id, 0)`
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var session Session
+	var elevatedUntil sql.NullTime
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.IP,
+		&session.UserAgent,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&elevatedUntil,
+		&session.ActiveGroupID,
+	)
+	session.ElevatedUntil = elevatedUntil.Time
+	return session, err
+}
+
+func (s *PostgresSessionStore) Create(tokenHash string, session Session) (int, error) {
+	var id int
+	err := internal.DB.QueryRow(
+		`INSERT INTO sessions (token_hash, user_id, ip, user_agent, created_at, last_seen_at, expires_at, elevated_until, active_group_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0))
+		 RETURNING id`,
+		tokenHash, session.UserID, session.IP, session.UserAgent,
+		session.CreatedAt, session.LastSeenAt, session.ExpiresAt, nullTime(session.ElevatedUntil), session.ActiveGroupID,
+	).Scan(&id)
+	return id, err
+}
+
+func (s *PostgresSessionStore) Get(tokenHash string) (Session, bool, error) {
+	session, err := scanSession(internal.DB.QueryRow(
+		`SELECT `+sessionColumns+` FROM sessions WHERE token_hash = $1`,
+		tokenHash,
+	))
+	if err == sql.ErrNoRows {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+	return session, true, nil
+}
+
+func (s *PostgresSessionStore) Touch(tokenHash string, at time.Time) error {
+	_, err := internal.DB.Exec(
+		`UPDATE sessions SET last_seen_at = $1 WHERE token_hash = $2`,
+		at, tokenHash,
+	)
+	return err
+}
+
+func (s *PostgresSessionStore) Elevate(id int, until time.Time) error {
+	_, err := internal.DB.Exec(
+		`UPDATE sessions SET elevated_until = $1 WHERE id = $2`,
+		until, id,
+	)
+	return err
+}
+
+func (s *PostgresSessionStore) SetActiveGroup(id, groupID int) error {
+	_, err := internal.DB.Exec(
+		`UPDATE sessions SET active_group_id = NULLIF($1, 0) WHERE id = $2`,
+		groupID, id,
+	)
+	return err
+}
+
+func (s *PostgresSessionStore) ListByUser(userID int) ([]Session, error) {
+	rows, err := internal.DB.Query(
+		`SELECT `+sessionColumns+`
+		   FROM sessions
+		  WHERE user_id = $1 AND expires_at > NOW()
+		  ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresSessionStore) Delete(tokenHash string) error {
+	_, err := internal.DB.Exec(`DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+func (s *PostgresSessionStore) DeleteByID(userID, id int) (bool, error) {
+	result, err := internal.DB.Exec(
+		`DELETE FROM sessions WHERE id = $1 AND user_id = $2`,
+		id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *PostgresSessionStore) DeleteByUser(userID, exceptID int) (int, error) {
+	result, err := internal.DB.Exec(
+		`DELETE FROM sessions WHERE user_id = $1 AND id <> $2`,
+		userID, exceptID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+func (s *PostgresSessionStore) Cleanup(now time.Time) error {
+	_, err := internal.DB.Exec(`DELETE FROM sessions WHERE expires_at < $1`, now)
+	return err
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
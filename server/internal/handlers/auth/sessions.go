@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type SessionInfo struct {
+	ID         int       `json:"id"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// currentSession returns the session the request was made with
+func currentSession(r *http.Request) (Session, bool) {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return Session{}, false
+	}
+	return GetSession(cookie.Value)
+}
+
+// LogoutHandler handles POST /logout
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		DeleteSession(cookie.Value)
+	}
+
+	// Expire the session cookie on the client
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Logged out successfully"})
+}
+
+// ListSessionsHandler handles GET /sessions
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, exists := currentSession(r)
+	if !exists {
+		http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := store.ListByUser(current.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:         s.ID,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == current.ID,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(infos)
+}
+
+// RevokeSessionHandler handles DELETE /sessions/{id}
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	current, exists := currentSession(r)
+	if !exists {
+		http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := store.DeleteByID(current.UserID, id)
+	if err != nil {
+		http.Error(w, "Failed to revoke session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":      id,
+		"current": id == current.ID,
+		"message": "Session revoked",
+	})
+}
+
+// RevokeOtherSessionsHandler handles DELETE /sessions
+func RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, exists := currentSession(r)
+	if !exists {
+		http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := store.DeleteByUser(current.UserID, current.ID)
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"revoked": revoked,
+		"message": "All other sessions revoked",
+	})
+}
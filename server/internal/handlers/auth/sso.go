@@ -0,0 +1,523 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"execute/internal"
+	"execute/internal/mail"
+	"execute/internal/oidc"
+)
+
+const ssoLoginDuration = 10 * time.Minute
+
+// errEmailTaken is returned when a new account would claim the verified email of an existing one
+var errEmailTaken = errors.New("email belongs to an existing account")
+
+// ssoStateCookie binds a login flow to the browser that started it, so a
+// callback URL from someone else's flow cannot log the victim in
+const ssoStateCookie = "sso_state"
+
+// sso holds the single sign-on configuration. The provider is discovered on
+// first use so the server starts even if the identity provider is down.
+var sso struct {
+	config        *oidc.Config // nil when single sign-on is disabled
+	autoProvision bool
+	provider      *oidc.Provider
+	mu            sync.Mutex
+}
+
+// LinkedIdentity is an external account linked to a user
+type LinkedIdentity struct {
+	ID        int       `json:"id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// InitSSO reads the OpenID Connect client configuration from the environment
+func InitSSO() {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		log.Println("single sign-on disabled, OIDC_ISSUER is not set")
+		return
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	if clientID == "" {
+		log.Fatal("OIDC_ISSUER requires OIDC_CLIENT_ID")
+	}
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = "http://localhost:8437/api/v1/sso/callback"
+	}
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	sso.config = &oidc.Config{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		AuthURL:      os.Getenv("OIDC_AUTH_URL"),
+	}
+	sso.autoProvision = true
+	if value := os.Getenv("OIDC_AUTO_PROVISION"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("invalid OIDC_AUTO_PROVISION %q: %v", value, err)
+		}
+		sso.autoProvision = parsed
+	}
+	log.Printf("single sign-on enabled with issuer %s", issuer)
+}
+
+// ssoProvider returns the discovered identity provider
+func ssoProvider(ctx context.Context) (*oidc.Provider, error) {
+	sso.mu.Lock()
+	defer sso.mu.Unlock()
+	if sso.provider != nil {
+		return sso.provider, nil
+	}
+	provider, err := oidc.Discover(ctx, *sso.config)
+	if err != nil {
+		return nil, err
+	}
+	sso.provider = provider
+	return provider, nil
+}
+
+// safeRedirect only allows paths inside the web app as redirect targets
+func safeRedirect(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// ssoFail sends the browser back to the web app's login page with an error
+func ssoFail(w http.ResponseWriter, r *http.Request, message string) {
+	http.Redirect(w, r, mail.AppURL("/login?sso_error="+url.QueryEscape(message)), http.StatusFound)
+}
+
+// startSSO redirects to the identity provider. A non-zero linkUserID links the
+// external account to that user instead of logging in.
+func startSSO(w http.ResponseWriter, r *http.Request, linkUserID int) {
+	if sso.config == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	provider, err := ssoProvider(r.Context())
+	if err != nil {
+		log.Printf("sso discovery failed: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	var linkUser sql.NullInt64
+	if linkUserID != 0 {
+		linkUser = sql.NullInt64{Int64: int64(linkUserID), Valid: true}
+	}
+	if _, err := internal.DB.Exec(
+		`INSERT INTO sso_logins (state_hash, nonce, code_verifier, link_user_id, redirect, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		HashToken(state), nonce, verifier, linkUser, safeRedirect(r.URL.Query().Get("redirect")),
+		time.Now().Add(ssoLoginDuration),
+	); err != nil {
+		http.Error(w, "Failed to start login: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(ssoLoginDuration.Seconds()),
+		HttpOnly: true,
+		// Lax, because the callback is a cross-site navigation from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier)), http.StatusFound)
+}
+
+// cleanupSSOLogins removes login flows that expired before now
+func cleanupSSOLogins(now time.Time) error {
+	_, err := internal.DB.Exec("DELETE FROM sso_logins WHERE expires_at < $1", now)
+	return err
+}
+
+// SSOLoginHandler handles GET /sso/login
+func SSOLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	startSSO(w, r, 0)
+}
+
+// SSOLinkHandler handles GET /sso/link
+func SSOLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	startSSO(w, r, userID)
+}
+
+// SSOCallbackHandler handles GET /sso/callback
+func SSOCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if sso.config == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(ssoStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		ssoFail(w, r, "Login session mismatch, please try again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: ssoStateCookie, Value: "", Path: "/", MaxAge: -1})
+
+	// The state is single use
+	var nonce, verifier, redirect string
+	var linkUser sql.NullInt64
+	err = internal.DB.QueryRow(
+		`DELETE FROM sso_logins
+		  WHERE state_hash = $1 AND expires_at > NOW()
+		  RETURNING nonce, code_verifier, link_user_id, redirect`,
+		HashToken(state),
+	).Scan(&nonce, &verifier, &linkUser, &redirect)
+	if err == sql.ErrNoRows {
+		ssoFail(w, r, "Login expired, please try again")
+		return
+	}
+	if err != nil {
+		ssoFail(w, r, "Failed to check login state")
+		return
+	}
+
+	if errCode := query.Get("error"); errCode != "" {
+		message := query.Get("error_description")
+		if message == "" {
+			message = errCode
+		}
+		ssoFail(w, r, "Identity provider: "+message)
+		return
+	}
+
+	provider, err := ssoProvider(r.Context())
+	if err != nil {
+		log.Printf("sso discovery failed: %v", err)
+		ssoFail(w, r, "Identity provider unavailable")
+		return
+	}
+	rawIDToken, err := provider.Exchange(r.Context(), query.Get("code"), verifier)
+	if err != nil {
+		log.Printf("sso code exchange failed: %v", err)
+		ssoFail(w, r, "Login with the identity provider failed")
+		return
+	}
+	claims, err := provider.VerifyIDToken(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		log.Printf("sso id token rejected: %v", err)
+		ssoFail(w, r, "Login with the identity provider failed")
+		return
+	}
+
+	var userID int
+	err = internal.DB.QueryRow(
+		"SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2",
+		claims.Issuer, claims.Subject,
+	).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		ssoFail(w, r, "Failed to look up account")
+		return
+	}
+	linked := err == nil
+
+	// Linking flow started by a logged in user
+	if linkUser.Valid {
+		switch {
+		case linked && userID != int(linkUser.Int64):
+			ssoFail(w, r, "This external account is already linked to another user")
+			return
+		case !linked:
+			if _, err := internal.DB.Exec(
+				`INSERT INTO user_identities (issuer, subject, user_id, email)
+				 VALUES ($1, $2, $3, $4)`,
+				claims.Issuer, claims.Subject, linkUser.Int64, claims.Email,
+			); err != nil {
+				ssoFail(w, r, "Failed to link account")
+				return
+			}
+		}
+		http.Redirect(w, r, mail.AppURL(redirect), http.StatusFound)
+		return
+	}
+
+	if !linked {
+		if !sso.autoProvision {
+			ssoFail(w, r, "No account is linked to this login, sign in with your password and link it first")
+			return
+		}
+		userID, err = provisionSSOUser(claims)
+		if err == errEmailTaken {
+			ssoFail(w, r, "An account with this email already exists, sign in with your password and link it first")
+			return
+		}
+		if err != nil {
+			log.Printf("sso provisioning failed: %v", err)
+			ssoFail(w, r, "Failed to create account")
+			return
+		}
+	}
+
+	// Two-factor authentication still applies to single sign-on
+	var totpEnabled bool
+	if err := internal.DB.QueryRow(
+		"SELECT totp_enabled FROM users WHERE id = $1", userID,
+	).Scan(&totpEnabled); err != nil {
+		ssoFail(w, r, "Failed to load account")
+		return
+	}
+	if totpEnabled {
+		pendingToken, err := createLoginChallenge(userID)
+		if err != nil {
+			ssoFail(w, r, "Failed to create login challenge")
+			return
+		}
+		http.Redirect(w, r, mail.AppURL("/login/2fa?pendingToken="+url.QueryEscape(pendingToken)+
+			"&redirect="+url.QueryEscape(redirect)), http.StatusFound)
+		return
+	}
+
+	token, err := CreateSession(userID, r)
+	if err != nil {
+		ssoFail(w, r, "Failed to create session")
+		return
+	}
+	setSessionCookie(w, token)
+	http.Redirect(w, r, mail.AppURL(redirect), http.StatusFound)
+}
+
+// ListIdentitiesHandler handles GET /sso/identities
+func ListIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT id, issuer, subject, COALESCE(email, ''), created_at
+		   FROM user_identities
+		  WHERE user_id = $1
+		  ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to query identities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	identities := make([]LinkedIdentity, 0)
+	for rows.Next() {
+		var identity LinkedIdentity
+		if err := rows.Scan(&identity.ID, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			http.Error(w, "Failed to scan identity: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Error iterating over identities: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(identities)
+}
+
+// UnlinkIdentityHandler handles DELETE /sso/identities/{id}
+func UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid identity ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Keep at least one way to sign in
+	var hasPassword bool
+	var others int
+	if err := tx.QueryRow(
+		`SELECT passwordhash <> '',
+		        (SELECT COUNT(*) FROM user_identities WHERE user_id = $1 AND id <> $2)
+		   FROM users WHERE id = $1
+		    FOR UPDATE`,
+		userID, id,
+	).Scan(&hasPassword, &others); err != nil {
+		http.Error(w, "Failed to load account: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := tx.Exec("DELETE FROM user_identities WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		http.Error(w, "Failed to unlink identity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+	if !hasPassword && others == 0 {
+		http.Error(w, "Cannot unlink the only way to sign in to this account", http.StatusConflict)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Identity unlinked"})
+}
+
+// provisionSSOUser creates an account for an external identity. The account has
+// no password; its username is derived from the claims and made unique.
+func provisionSSOUser(claims *oidc.Claims) (int, error) {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Only take over the email if the provider vouches for it
+	var email sql.NullString
+	var emailVerifiedAt sql.NullTime
+	if claims.Email != "" && claims.EmailVerified {
+		var exists bool
+		if err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND email_verified_at IS NOT NULL)",
+			claims.Email,
+		).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, errEmailTaken
+		}
+		email = sql.NullString{String: claims.Email, Valid: true}
+		emailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	var displayName sql.NullString
+	if claims.Name != "" {
+		displayName = sql.NullString{String: claims.Name, Valid: true}
+	}
+
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	var userID int
+	for attempt := 1; ; attempt++ {
+		username := base
+		if attempt > 1 {
+			username = base + "-" + strconv.Itoa(attempt)
+		}
+		if attempt > 20 {
+			suffix, err := GenerateSessionToken()
+			if err != nil {
+				return 0, err
+			}
+			username = base + "-" + HashToken(suffix)[:8]
+		}
+		err = tx.QueryRow(
+			`INSERT INTO users (username, passwordhash, display_name, email, email_verified_at)
+			 VALUES ($1, '', $2, $3, $4)
+			 ON CONFLICT (username) DO NOTHING
+			 RETURNING id`,
+			username, displayName, email, emailVerifiedAt,
+		).Scan(&userID)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows || attempt > 20 {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO user_identities (issuer, subject, user_id, email)
+		 VALUES ($1, $2, $3, $4)`,
+		claims.Issuer, claims.Subject, userID, claims.Email,
+	); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"execute/internal"
+)
+
+// ErrNotSession is returned when a request authenticated with an access token uses session state
+var ErrNotSession = errors.New("only available to browser sessions, not access tokens")
+
+// sudoDuration is how long a session stays elevated after a fresh password or 2FA check
+var sudoDuration = loadSudoDuration()
+
+func loadSudoDuration() time.Duration {
+	d := 10 * time.Minute
+	if value := os.Getenv("SUDO_DURATION"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("invalid SUDO_DURATION %q, using %s", value, d)
+		} else {
+			d = parsed
+		}
+	}
+	return d
+}
+
+// Elevated reports whether the identity recently re-authenticated.
+// Access tokens are never elevated.
+func (i Identity) Elevated() bool {
+	return i.TokenID == 0 && i.SessionID != 0 && time.Now().Before(i.ElevatedUntil)
+}
+
+// RequireElevated writes a 403 response and returns false unless the request
+// was made with an elevated session. Guard sensitive account changes with it.
+func RequireElevated(w http.ResponseWriter, r *http.Request) bool {
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if !principal.Elevated() {
+		w.Header().Set("X-Reauth-Required", "true")
+		http.Error(w, "Recent authentication required, confirm your identity with POST /sudo", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ElevateSession elevates the session of the request after the caller verified
+// the user's password or second factor
+func ElevateSession(r *http.Request) (time.Time, error) {
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if principal.SessionID == 0 {
+		return time.Time{}, ErrNotSession
+	}
+	until := time.Now().Add(sudoDuration)
+	if err := store.Elevate(principal.SessionID, until); err != nil {
+		return time.Time{}, err
+	}
+	// Later checks within the same request see the new window as well
+	principal.ElevatedUntil = until
+	return until, nil
+}
+
+// ConfirmPassword checks the current user's password and elevates the session
+// on success. Wrong passwords count towards the login lockout, so a stolen
+// session cannot be used to guess the password. On failure the response has
+// been written and false is returned.
+func ConfirmPassword(w http.ResponseWriter, r *http.Request, password string) bool {
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	userID := principal.UserID
+
+	lockedUntil, err := accountLockedUntil(userID)
+	if err != nil {
+		http.Error(w, "Failed to check account lock: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !lockedUntil.IsZero() {
+		writeLocked(w, lockedUntil)
+		return false
+	}
+
+	ok, err := CheckUserPassword(userID, password)
+	if err != nil {
+		http.Error(w, "Failed to verify password: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		if until, err := recordFailedLogin(userID); err != nil {
+			log.Printf("failed to record failed login: %v", err)
+		} else if !until.IsZero() {
+			writeLocked(w, until)
+			return false
+		}
+		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+		return false
+	}
+
+	// Access tokens cannot be elevated; the password was still correct
+	if _, err := ElevateSession(r); err != nil && err != ErrNotSession {
+		http.Error(w, "Failed to elevate session: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+type sudoReq struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type SudoResponse struct {
+	Elevated      bool       `json:"elevated"`
+	ElevatedUntil *time.Time `json:"elevatedUntil,omitempty"`
+}
+
+// SudoStatusHandler handles GET /sudo
+func SudoStatusHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	resp := SudoResponse{Elevated: principal.Elevated()}
+	if resp.Elevated {
+		resp.ElevatedUntil = &principal.ElevatedUntil
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SudoHandler handles POST /sudo
+func SudoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	var req sudoReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case req.Password != "":
+		if !ConfirmPassword(w, r, req.Password) {
+			return
+		}
+
+	case req.Code != "" || req.RecoveryCode != "":
+		lockedUntil, err := accountLockedUntil(userID)
+		if err != nil {
+			http.Error(w, "Failed to check account lock: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !lockedUntil.IsZero() {
+			writeLocked(w, lockedUntil)
+			return
+		}
+		var totpEnabled bool
+		if err := internal.DB.QueryRow(
+			"SELECT totp_enabled FROM users WHERE id = $1", userID,
+		).Scan(&totpEnabled); err != nil {
+			http.Error(w, "Failed to load account: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !totpEnabled {
+			http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+			return
+		}
+		ok, err := verifySecondFactor(userID, req.Code, req.RecoveryCode)
+		if err != nil {
+			http.Error(w, "Failed to verify code: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			if until, err := recordFailedLogin(userID); err != nil {
+				log.Printf("failed to record failed login: %v", err)
+			} else if !until.IsZero() {
+				writeLocked(w, until)
+				return
+			}
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+
+	default:
+		http.Error(w, "Password or two-factor code is required", http.StatusBadRequest)
+		return
+	}
+
+	until, err := ElevateSession(r)
+	if err == ErrNotSession {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to elevate session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SudoResponse{Elevated: true, ElevatedUntil: &until})
+}
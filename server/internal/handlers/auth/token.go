@@ -2,25 +2,77 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"sync"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
 	"time"
+
+	"execute/internal/utils"
 )
 
 // Session represents a user session with an associated expiration time
 type Session struct {
-	Username  string
-	ExpiresAt time.Time
+	ID         int
+	UserID     int
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// ElevatedUntil ends the window after a fresh password or 2FA check in which
+	// sensitive account changes are allowed (zero if never elevated)
+	ElevatedUntil time.Time
+	// ActiveGroupID is the group selected for this session (0 for the user's first group)
+	ActiveGroupID int
+}
+
+// SessionStore persists sessions keyed by the SHA-256 hash of their token
+type SessionStore interface {
+	// Create stores a new session under the given token hash and returns its ID
+	Create(tokenHash string, session Session) (int, error)
+	// Get returns the session stored under the given token hash
+	Get(tokenHash string) (Session, bool, error)
+	// Touch records that the session was used at the given time
+	Touch(tokenHash string, at time.Time) error
+	// Elevate extends the elevated window of the session with the given ID
+	Elevate(id int, until time.Time) error
+	// SetActiveGroup selects the group requests of the session with the given ID act on
+	SetActiveGroup(id, groupID int) error
+	// ListByUser returns all sessions of a user, newest first
+	ListByUser(userID int) ([]Session, error)
+	// Delete removes the session stored under the given token hash
+	Delete(tokenHash string) error
+	// DeleteByID removes a session of the user by its ID and reports whether it existed
+	DeleteByID(userID, id int) (bool, error)
+	// DeleteByUser removes all sessions of a user except the one with the given ID (0 keeps none)
+	DeleteByUser(userID, exceptID int) (int, error)
+	// Cleanup removes all sessions that expired before now
+	Cleanup(now time.Time) error
 }
 
-const sessionDuration = 7 * 24 * time.Hour
+const (
+	sessionDuration = 7 * 24 * time.Hour
+	// sessionTouchInterval limits how often last-seen timestamps are written
+	sessionTouchInterval = time.Minute
+)
 
-// sessionStore holds the sessions with thread-safe access
-var sessionStore = struct {
-	sessions map[string]Session
-	sync.RWMutex
-}{
-	sessions: make(map[string]Session),
+// store is the session backend used by the package level helpers
+var store SessionStore = NewMemorySessionStore()
+
+// InitSessionStore selects the session backend from the SESSION_STORE environment variable.
+// Supported values are "postgres" (default) and "memory".
+func InitSessionStore() {
+	switch backend := os.Getenv("SESSION_STORE"); backend {
+	case "", "postgres":
+		store = NewPostgresSessionStore()
+	case "memory":
+		store = NewMemorySessionStore()
+	default:
+		log.Fatalf("unknown SESSION_STORE %q (expected postgres or memory)", backend)
+	}
 }
 
 // GenerateSessionToken creates a random, Base64-encoded token to be used as a session identifier
@@ -32,56 +84,105 @@ func GenerateSessionToken() (string, error) {
 	return base64.StdEncoding.EncodeToString(tokenBytes), nil
 }
 
-// CreateSession adds a new session for the specified username with an expiration timestamp
-func CreateSession(username string) (string, error) {
+// HashToken returns the hex-encoded SHA-256 hash of a token, which is what the stores persist
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession adds a new session for the specified user with an expiration timestamp.
+// The client IP and user agent of the request are recorded for the session list.
+func CreateSession(userID int, r *http.Request) (string, error) {
 	token, err := GenerateSessionToken()
 	if err != nil {
 		return "", err
 	}
-	sessionStore.Lock()
-	defer sessionStore.Unlock()
-	sessionStore.sessions[token] = Session{
-		Username:  username,
-		ExpiresAt: time.Now().Add(sessionDuration),
+	now := time.Now()
+	// Logging in is a fresh authentication, so new sessions start out elevated
+	_, err = store.Create(HashToken(token), Session{
+		UserID:        userID,
+		IP:            utils.GetIP(r),
+		UserAgent:     r.UserAgent(),
+		CreatedAt:     now,
+		LastSeenAt:    now,
+		ExpiresAt:     now.Add(sessionDuration),
+		ElevatedUntil: now.Add(sudoDuration),
+	})
+	if err != nil {
+		return "", err
 	}
 	return token, nil
 }
 
-// GetSessionUsername retrieves the username associated with a session token if it is not expired
-func GetSessionUsername(token string) (string, bool) {
-	sessionStore.RLock()
-	session, exists := sessionStore.sessions[token]
-	sessionStore.RUnlock()
-	if !exists || time.Now().After(session.ExpiresAt) {
+// GetSession retrieves the session for a token if it is not expired and refreshes its last-seen time
+func GetSession(token string) (Session, bool) {
+	tokenHash := HashToken(token)
+	session, exists, err := store.Get(tokenHash)
+	if err != nil {
+		log.Printf("session lookup failed: %v", err)
+		return Session{}, false
+	}
+	now := time.Now()
+	if !exists || now.After(session.ExpiresAt) {
 		// If the session is expired, clean it up
 		DeleteSession(token)
-		return "", false
+		return Session{}, false
+	}
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := store.Touch(tokenHash, now); err != nil {
+			log.Printf("failed to touch session: %v", err)
+		}
+		session.LastSeenAt = now
 	}
-	return session.Username, true
+	return session, true
+}
+
+// GetSessionUserID retrieves the user ID associated with a session token if it is not expired
+func GetSessionUserID(token string) (int, bool) {
+	session, exists := GetSession(token)
+	return session.UserID, exists
 }
 
 // DeleteSession removes a session token from the store
 func DeleteSession(token string) {
-	sessionStore.Lock()
-	defer sessionStore.Unlock()
-	delete(sessionStore.sessions, token)
+	if err := store.Delete(HashToken(token)); err != nil {
+		log.Printf("failed to delete session: %v", err)
+	}
 }
 
-// sessionStoreCleanup iterates over the sessions and deletes expired ones
-func sessionStoreCleanup() {
-	sessionStore.Lock()
-	defer sessionStore.Unlock()
-	now := time.Now()
-	for token, session := range sessionStore.sessions {
-		if now.After(session.ExpiresAt) {
-			delete(sessionStore.sessions, token)
+// RevokeOtherSessions removes every session of the user except the one the request was made with
+func RevokeOtherSessions(r *http.Request, userID int) (int, error) {
+	exceptID := 0
+	if cookie, err := r.Cookie("session_token"); err == nil {
+		if session, exists := GetSession(cookie.Value); exists && session.UserID == userID {
+			exceptID = session.ID
 		}
 	}
+	return store.DeleteByUser(userID, exceptID)
 }
 
+// RevokeAllSessions removes every session of the user
+func RevokeAllSessions(userID int) error {
+	_, err := store.DeleteByUser(userID, 0)
+	return err
+}
+
+// CleanupExpiredSessions periodically removes expired sessions, pending logins, emailed tokens and sso flows
 func CleanupExpiredSessions(interval time.Duration) {
 	for {
 		time.Sleep(interval)
-		sessionStoreCleanup()
+		now := time.Now()
+		if err := store.Cleanup(now); err != nil {
+			log.Printf("session cleanup failed: %v", err)
+		}
+		if err := cleanupLoginChallenges(now); err != nil {
+			log.Printf("login challenge cleanup failed: %v", err)
+		}
+		if err := cleanupUserTokens(now); err != nil {
+			log.Printf("user token cleanup failed: %v", err)
+		}
+		if err := cleanupSSOLogins(now); err != nil {
+			log.Printf("sso login cleanup failed: %v", err)
+		}
 	}
 }
@@ -0,0 +1,605 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"execute/internal"
+)
+
+const (
+	loginChallengeDuration    = 5 * time.Minute
+	loginChallengeMaxAttempts = 5
+	recoveryCodeCount         = 10
+)
+
+type TwoFactorRequiredResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	PendingToken      string `json:"pendingToken"`
+}
+
+type TwoFactorStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+type EnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qrCode"`
+}
+
+type RecoveryCodesResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type twoFactorCodeReq struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type disableTwoFactorReq struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type loginTwoFactorReq struct {
+	PendingToken string `json:"pendingToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// totpIssuer returns the issuer shown in authenticator apps
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Execute"
+}
+
+// pendingTOTPURI loads the user's TOTP secret and builds its otpauth URI
+func pendingTOTPURI(userID int) (secret, uri string, enabled bool, err error) {
+	var username string
+	var storedSecret sql.NullString
+	err = internal.DB.QueryRow(
+		"SELECT username, totp_secret, totp_enabled FROM users WHERE id = $1", userID,
+	).Scan(&username, &storedSecret, &enabled)
+	if err != nil {
+		return "", "", false, err
+	}
+	if !storedSecret.Valid {
+		return "", "", enabled, nil
+	}
+	return storedSecret.String, TOTPURI(totpIssuer(), username, storedSecret.String), enabled, nil
+}
+
+// normalizeRecoveryCode strips formatting so codes can be typed with or without dashes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the new plaintext codes
+func generateRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		if _, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, HashToken(raw),
+		); err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// verifySecondFactor checks a TOTP code, or consumes a recovery code if no TOTP code is given.
+// A TOTP code is only accepted once, so a code seen on screen cannot be replayed.
+func verifySecondFactor(userID int, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		var secret sql.NullString
+		if err := internal.DB.QueryRow(
+			"SELECT totp_secret FROM users WHERE id = $1", userID,
+		).Scan(&secret); err != nil {
+			return false, err
+		}
+		if !secret.Valid {
+			return false, nil
+		}
+		step, ok := ValidateTOTP(secret.String, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		result, err := internal.DB.Exec(
+			"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1",
+			step, userID,
+		)
+		if err != nil {
+			return false, err
+		}
+		affected, err := result.RowsAffected()
+		return affected == 1, err
+	}
+
+	if recoveryCode != "" {
+		result, err := internal.DB.Exec(
+			`UPDATE recovery_codes SET used_at = NOW()
+			  WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+			userID, HashToken(normalizeRecoveryCode(recoveryCode)),
+		)
+		if err != nil {
+			return false, err
+		}
+		affected, err := result.RowsAffected()
+		return affected == 1, err
+	}
+
+	return false, nil
+}
+
+// createLoginChallenge stores a short-lived pending-2FA token for a user who passed the password check
+func createLoginChallenge(userID int) (string, error) {
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = internal.DB.Exec(
+		`INSERT INTO login_challenges (token_hash, user_id, expires_at)
+		 VALUES ($1, $2, $3)`,
+		HashToken(token), userID, time.Now().Add(loginChallengeDuration),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// cleanupLoginChallenges removes pending-2FA tokens that expired before now
+func cleanupLoginChallenges(now time.Time) error {
+	_, err := internal.DB.Exec("DELETE FROM login_challenges WHERE expires_at < $1", now)
+	return err
+}
+
+// TwoFactorStatusHandler handles GET /2fa
+func TwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var status TwoFactorStatusResponse
+	err = internal.DB.QueryRow(
+		`SELECT u.totp_enabled,
+		        (SELECT COUNT(*) FROM recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL)
+		   FROM users u
+		  WHERE u.id = $1`,
+		userID,
+	).Scan(&status.Enabled, &status.RecoveryCodesRemaining)
+	if err != nil {
+		http.Error(w, "Failed to load two-factor status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// EnrollTwoFactorHandler handles POST /2fa/enroll
+func EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	// Only replace the secret while two-factor authentication is not active
+	result, err := internal.DB.Exec(
+		"UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2 AND NOT totp_enabled",
+		secret, userID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to store secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	_, uri, _, err := pendingTOTPURI(userID)
+	if err != nil {
+		http.Error(w, "Failed to build otpauth URI: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Failed to render QR code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(EnrollResponse{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// TwoFactorQRHandler handles GET /2fa/qr and serves the pending enrollment as a PNG image
+func TwoFactorQRHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, uri, enabled, err := pendingTOTPURI(userID)
+	if err != nil {
+		http.Error(w, "Failed to load secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if uri == "" {
+		http.Error(w, "No pending enrollment, call /2fa/enroll first", http.StatusNotFound)
+		return
+	}
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Failed to render QR code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+// ActivateTwoFactorHandler handles POST /2fa/activate
+func ActivateTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req twoFactorCodeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	secret, _, enabled, err := pendingTOTPURI(userID)
+	if err != nil {
+		http.Error(w, "Failed to load secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if secret == "" {
+		http.Error(w, "No pending enrollment, call /2fa/enroll first", http.StatusNotFound)
+		return
+	}
+
+	ok, err := verifySecondFactor(userID, req.Code, "")
+	if err != nil {
+		http.Error(w, "Failed to verify code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = TRUE WHERE id = $1", userID); err != nil {
+		http.Error(w, "Failed to enable two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	codes, err := generateRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{
+		Message:       "Two-factor authentication enabled",
+		RecoveryCodes: codes,
+	})
+}
+
+// RegenerateRecoveryCodesHandler handles POST /2fa/recovery-codes
+func RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req twoFactorCodeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var enabled bool
+	if err := internal.DB.QueryRow(
+		"SELECT totp_enabled FROM users WHERE id = $1", userID,
+	).Scan(&enabled); err != nil {
+		http.Error(w, "User lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	ok, err := verifySecondFactor(userID, req.Code, "")
+	if err != nil {
+		http.Error(w, "Failed to verify code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := generateRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{
+		Message:       "Recovery codes regenerated",
+		RecoveryCodes: codes,
+	})
+}
+
+// DisableTwoFactorHandler handles POST /2fa/disable
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req disableTwoFactorReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		http.Error(w, "Current password is required", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := CheckUserPassword(userID, req.Password)
+	if err != nil {
+		http.Error(w, "Failed to verify password: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err = verifySecondFactor(userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		http.Error(w, "Failed to verify code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1",
+		userID,
+	); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		http.Error(w, "Failed to delete recovery codes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Message: "Two-factor authentication disabled"})
+}
+
+// LoginTwoFactorHandler handles POST /login/2fa and exchanges a pending token for a session
+func LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req loginTwoFactorReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.PendingToken == "" {
+		http.Error(w, "Pending token is required", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		http.Error(w, "Code or recovery code is required", http.StatusBadRequest)
+		return
+	}
+
+	// Count the attempt up front so a pending token cannot be brute-forced
+	var userID, attempts int
+	err := internal.DB.QueryRow(
+		`UPDATE login_challenges
+		    SET attempts = attempts + 1
+		  WHERE token_hash = $1 AND expires_at > NOW()
+		  RETURNING user_id, attempts`,
+		HashToken(req.PendingToken),
+	).Scan(&userID, &attempts)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired pending token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Challenge lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if attempts > loginChallengeMaxAttempts {
+		internal.DB.Exec("DELETE FROM login_challenges WHERE token_hash = $1", HashToken(req.PendingToken))
+		http.Error(w, "Too many attempts, log in again", http.StatusUnauthorized)
+		return
+	}
+
+	// Failed codes count towards the account lockout just like wrong passwords
+	lockedUntil, err := accountLockedUntil(userID)
+	if err != nil {
+		http.Error(w, "User lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		writeLocked(w, lockedUntil)
+		return
+	}
+
+	ok, err := verifySecondFactor(userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		http.Error(w, "Failed to verify code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		until, err := recordFailedLogin(userID)
+		if err != nil {
+			log.Printf("failed to record failed login: %v", err)
+		} else if !until.IsZero() {
+			writeLocked(w, until)
+			return
+		}
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err := resetFailedLogins(userID); err != nil {
+		log.Printf("failed to reset failed logins: %v", err)
+	}
+
+	if _, err := internal.DB.Exec(
+		"DELETE FROM login_challenges WHERE token_hash = $1", HashToken(req.PendingToken),
+	); err != nil {
+		http.Error(w, "Failed to consume pending token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := CreateSession(userID, r)
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, token)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TokenResponse{Token: token})
+}
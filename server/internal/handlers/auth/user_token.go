@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"execute/internal"
+)
+
+// Purposes of single-use tokens sent to users by email
+const (
+	tokenPurposePasswordReset = "password_reset"
+	tokenPurposeEmailVerify   = "email_verify"
+)
+
+const (
+	passwordResetTokenDuration = time.Hour
+	emailVerifyTokenDuration   = 48 * time.Hour
+)
+
+// errInvalidUserToken is returned for unknown, expired or already used tokens
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// createUserToken issues a single-use token for the given purpose. Earlier unused
+// tokens of the same purpose are invalidated, so only the newest link works.
+// email records the address the token was sent to.
+func createUserToken(userID int, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID, purpose,
+	); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		HashToken(token), userID, purpose, email, time.Now().Add(ttl),
+	); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// consumeUserToken marks a token as used and returns the user and email it was issued for
+func consumeUserToken(tx *sql.Tx, token, purpose string) (int, string, error) {
+	var userID int
+	var email string
+	err := tx.QueryRow(
+		`UPDATE user_tokens
+		    SET used_at = NOW()
+		  WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		  RETURNING user_id, email`,
+		HashToken(token), purpose,
+	).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", errInvalidUserToken
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, email, nil
+}
+
+// cleanupUserTokens removes tokens that expired before now
+func cleanupUserTokens(now time.Time) error {
+	_, err := internal.DB.Exec("DELETE FROM user_tokens WHERE expires_at < $1", now)
+	return err
+}
@@ -18,29 +18,9 @@ func ValidateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the session token from cookies
-	cookie, err := r.Cookie("session_token")
+	// Check if the session or access token is valid
+	principal, err := GetPrincipal(r)
 	if err != nil {
-		if err == http.ErrNoCookie {
-			http.Error(w, "No session token found", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, "Error retrieving session token: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Get the token from the cookie
-	token := cookie.Value
-
-	// Check if the session is valid
-	username, exists := GetSessionUsername(token)
-	if !exists {
-		http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
-		return
-	}
-
-	id, err := GetUserID(r)
-	if !exists {
 		http.Error(w, "Invalid or expired session token", http.StatusUnauthorized)
 		return
 	}
@@ -49,7 +29,7 @@ func ValidateHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(ValidateResponse{
 		Message: "Session is valid",
-		User:    username,
-		ID:      id,
+		User:    principal.Username,
+		ID:      principal.UserID,
 	})
 }
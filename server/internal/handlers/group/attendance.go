@@ -0,0 +1,492 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+const (
+	maxAttendanceBonus = 1000
+	rsvpMaxComment     = 500
+)
+
+type rsvpReq struct {
+	Occurrence time.Time `json:"occurrence"`
+	Response   string    `json:"response"` // yes, no or maybe
+	Comment    string    `json:"comment"`
+}
+
+type attendanceEntry struct {
+	UserID   int  `json:"userId"`
+	Attended bool `json:"attended"`
+}
+
+type attendanceReq struct {
+	Occurrence time.Time         `json:"occurrence"`
+	Attendees  []attendanceEntry `json:"attendees"`
+}
+
+// RSVP is a member's answer for one occurrence of a meeting
+type RSVP struct {
+	UserID    int       `json:"userId"`
+	Username  string    `json:"username"`
+	Response  string    `json:"response"`
+	Comment   string    `json:"comment,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Attendance is whether a member was at one occurrence of a meeting
+type Attendance struct {
+	UserID   int       `json:"userId"`
+	Username string    `json:"username"`
+	Attended bool      `json:"attended"`
+	MarkedBy *int      `json:"markedBy,omitempty"`
+	MarkedAt time.Time `json:"markedAt"`
+}
+
+// OccurrenceDetails are the RSVPs and attendance of one occurrence
+type OccurrenceDetails struct {
+	Occurrence
+	RSVPs        []RSVP       `json:"rsvps"`
+	Attendance   []Attendance `json:"attendance"`
+	BonusAwarded int          `json:"bonusAwarded,omitempty"`
+}
+
+// AttendanceRate is one member's line in the attendance report
+type AttendanceRate struct {
+	UserID   int      `json:"userId"`
+	Username string   `json:"username"`
+	Expected int      `json:"expected"`
+	Attended int      `json:"attended"`
+	Rate     *float64 `json:"rate"` // null if no meeting was tracked since the member joined
+}
+
+// findOccurrence loads the meeting and checks that it has a not cancelled
+// occurrence starting at at. On failure the response has been written.
+func findOccurrence(w http.ResponseWriter, groupID, meetingID int, at time.Time) (*Meeting, bool) {
+	meetings, err := loadMeetings(groupID, meetingID)
+	if err != nil {
+		http.Error(w, "Failed to load meeting: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(meetings) == 0 {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return nil, false
+	}
+	m := &meetings[0]
+	starts, err := m.Occurrences(at, at.Add(time.Second), 1)
+	if err != nil {
+		http.Error(w, "Failed to expand meeting: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(starts) == 0 || !starts[0].Equal(at) {
+		http.Error(w, "The meeting has no occurrence at this time", http.StatusNotFound)
+		return nil, false
+	}
+	return m, true
+}
+
+// occurrenceDetails reads the RSVPs, attendance and bonus of an occurrence
+func occurrenceDetails(m *Meeting, at time.Time) (*OccurrenceDetails, error) {
+	d := &OccurrenceDetails{
+		Occurrence: m.Occurrence(at),
+		RSVPs:      make([]RSVP, 0),
+		Attendance: make([]Attendance, 0),
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT r.user_id, u.username, r.response, COALESCE(r.comment, ''), r.updated_at
+		   FROM meeting_rsvps r
+		   JOIN users u ON u.id = r.user_id
+		  WHERE r.meeting_id = $1 AND r.occurs_at = $2
+		  ORDER BY u.username`,
+		m.ID, at,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var r RSVP
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Response, &r.Comment, &r.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.RSVPs = append(d.RSVPs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = internal.DB.Query(
+		`SELECT a.user_id, u.username, a.attended, a.marked_by, a.marked_at
+		   FROM meeting_attendance a
+		   JOIN users u ON u.id = a.user_id
+		  WHERE a.meeting_id = $1 AND a.occurs_at = $2
+		  ORDER BY u.username`,
+		m.ID, at,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Attendance
+		var markedBy sql.NullInt64
+		if err := rows.Scan(&a.UserID, &a.Username, &a.Attended, &markedBy, &a.MarkedAt); err != nil {
+			return nil, err
+		}
+		a.MarkedBy = nullableInt(markedBy)
+		d.Attendance = append(d.Attendance, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = internal.DB.QueryRow(
+		"SELECT COALESCE((SELECT points FROM meeting_bonuses WHERE meeting_id = $1 AND occurs_at = $2), 0)",
+		m.ID, at,
+	).Scan(&d.BonusAwarded)
+	return d, err
+}
+
+// awardAttendanceBonus credits the group's bonus once per occurrence if the
+// share of members that attended reaches the threshold. Members who joined
+// after the occurrence are not counted. It returns the points awarded.
+func awardAttendanceBonus(tx *sql.Tx, groupID, meetingID int, at time.Time) (int, error) {
+	var points, threshold int
+	var archived bool
+	if err := tx.QueryRow(
+		`SELECT attendance_bonus_points, attendance_bonus_threshold, archived_at IS NOT NULL
+		   FROM groups
+		  WHERE id = $1
+		    FOR UPDATE`,
+		groupID,
+	).Scan(&points, &threshold, &archived); err != nil {
+		return 0, err
+	}
+	if points == 0 || archived {
+		return 0, nil
+	}
+
+	var attended, expected int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FILTER (WHERE a.attended), COUNT(*)
+		   FROM group_memberships m
+		   LEFT JOIN meeting_attendance a
+		          ON a.meeting_id = $2 AND a.occurs_at = $3 AND a.user_id = m.user_id
+		  WHERE m.group_id = $1 AND m.joined_at <= $3`,
+		groupID, meetingID, at,
+	).Scan(&attended, &expected); err != nil {
+		return 0, err
+	}
+	if expected == 0 || attended*100 < threshold*expected {
+		return 0, nil
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO meeting_bonuses (meeting_id, occurs_at, points)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		meetingID, at, points,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	// Like a completed task, the bonus fills the pool and the score
+	if _, err := tx.Exec(
+		"UPDATE groups SET points = points + $1, points_score = points_score + $1 WHERE id = $2",
+		points, groupID,
+	); err != nil {
+		return 0, err
+	}
+	return points, nil
+}
+
+// RSVPHandler handles PUT /group/meetings/{id}/rsvp
+func RSVPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	id, ok := meetingID(w, r)
+	if !ok {
+		return
+	}
+
+	var req rsvpReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Response != "yes" && req.Response != "no" && req.Response != "maybe" {
+		http.Error(w, `response must be "yes", "no" or "maybe"`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Comment) > rsvpMaxComment {
+		http.Error(w, "comment must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	m, ok := findOccurrence(w, groupID, id, req.Occurrence)
+	if !ok {
+		return
+	}
+	if time.Now().After(m.Occurrence(req.Occurrence).End) {
+		http.Error(w, "The meeting is over", http.StatusConflict)
+		return
+	}
+
+	if _, err := internal.DB.Exec(
+		`INSERT INTO meeting_rsvps (meeting_id, occurs_at, user_id, response, comment)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		 ON CONFLICT (meeting_id, occurs_at, user_id) DO UPDATE
+		    SET response = EXCLUDED.response, comment = EXCLUDED.comment, updated_at = NOW()`,
+		id, req.Occurrence, principal.UserID, req.Response, req.Comment,
+	); err != nil {
+		http.Error(w, "Could not save RSVP: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "RSVP saved"})
+}
+
+// OccurrenceDetailsHandler handles GET /group/meetings/{id}/occurrence
+func OccurrenceDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	id, ok := meetingID(w, r)
+	if !ok {
+		return
+	}
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("at"))
+	if err != nil {
+		http.Error(w, "at must be the RFC 3339 start of an occurrence", http.StatusBadRequest)
+		return
+	}
+
+	m, ok := findOccurrence(w, groupID, id, at)
+	if !ok {
+		return
+	}
+	details, err := occurrenceDetails(m, at)
+	if err != nil {
+		http.Error(w, "Failed to load occurrence: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(details)
+}
+
+// MarkAttendanceHandler handles PUT /group/meetings/{id}/attendance
+func MarkAttendanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+		return
+	}
+
+	id, ok := meetingID(w, r)
+	if !ok {
+		return
+	}
+
+	var req attendanceReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Attendees) == 0 {
+		http.Error(w, "attendees is required", http.StatusBadRequest)
+		return
+	}
+
+	m, ok := findOccurrence(w, groupID, id, req.Occurrence)
+	if !ok {
+		return
+	}
+	if time.Now().Before(req.Occurrence) {
+		http.Error(w, "Attendance can only be marked once the meeting has started", http.StatusConflict)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, a := range req.Attendees {
+		// Only members can be marked, former members keep their records
+		result, err := tx.Exec(
+			`INSERT INTO meeting_attendance (meeting_id, occurs_at, user_id, attended, marked_by)
+			 SELECT $1, $2, $3, $4, $5
+			  WHERE EXISTS (SELECT 1 FROM group_memberships WHERE group_id = $6 AND user_id = $3)
+			 ON CONFLICT (meeting_id, occurs_at, user_id) DO UPDATE
+			    SET attended = EXCLUDED.attended, marked_by = EXCLUDED.marked_by, marked_at = NOW()`,
+			id, req.Occurrence, a.UserID, a.Attended, principal.UserID, groupID,
+		)
+		if err != nil {
+			http.Error(w, "Could not mark attendance: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "User is not a member of the group", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := awardAttendanceBonus(tx, groupID, id, req.Occurrence); err != nil {
+		http.Error(w, "Could not award attendance bonus: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	details, err := occurrenceDetails(m, req.Occurrence)
+	if err != nil {
+		http.Error(w, "Failed to load occurrence: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(details)
+}
+
+// AttendanceReportHandler handles GET /group/attendance
+func AttendanceReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Without a range the whole history counts
+	from := time.Unix(0, 0)
+	to := time.Now()
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Only occurrences with recorded attendance count, a member without a
+	// record for one of them was absent
+	rows, err := internal.DB.Query(
+		`WITH tracked AS (
+		     SELECT DISTINCT a.meeting_id, a.occurs_at
+		       FROM meeting_attendance a
+		       JOIN meetings mt ON mt.id = a.meeting_id
+		      WHERE mt.group_id = $1 AND a.occurs_at >= $2 AND a.occurs_at < $3
+		 )
+		 SELECT m.user_id, u.username,
+		        COUNT(t.occurs_at),
+		        COUNT(a.user_id) FILTER (WHERE a.attended)
+		   FROM group_memberships m
+		   JOIN users u ON u.id = m.user_id
+		   LEFT JOIN tracked t ON t.occurs_at >= m.joined_at
+		   LEFT JOIN meeting_attendance a
+		          ON a.meeting_id = t.meeting_id AND a.occurs_at = t.occurs_at AND a.user_id = m.user_id
+		  WHERE m.group_id = $1
+		  GROUP BY m.user_id, u.username
+		  ORDER BY u.username`,
+		groupID, from, to,
+	)
+	if err != nil {
+		http.Error(w, "Failed to query attendance: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	report := make([]AttendanceRate, 0)
+	for rows.Next() {
+		var a AttendanceRate
+		if err := rows.Scan(&a.UserID, &a.Username, &a.Expected, &a.Attended); err != nil {
+			http.Error(w, "Failed to scan attendance: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if a.Expected > 0 {
+			rate := float64(a.Attended) / float64(a.Expected)
+			a.Rate = &rate
+		}
+		report = append(report, a)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Error iterating over attendance: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
@@ -16,3 +16,12 @@ func NewCode() (string, error) {
 	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
 	return code, nil
 }
+
+// NewInviteCode returns a longer base32 code for invite links, 10 bytes → 16 chars
+func NewInviteCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
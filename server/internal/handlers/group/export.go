@@ -0,0 +1,574 @@
+package group
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"execute/internal"
+	"execute/internal/audit"
+	"execute/internal/handlers/auth"
+)
+
+// GroupExport is the group itself in a group export
+type GroupExport struct {
+	ID                       int        `json:"id"`
+	Name                     string     `json:"name"`
+	Code                     string     `json:"code"`
+	Points                   int        `json:"points"`
+	PointsScore              int        `json:"pointsScore"`
+	Meeting                  *time.Time `json:"meeting,omitempty"`
+	ArchivedAt               *time.Time `json:"archivedAt,omitempty"`
+	JoinApproval             bool       `json:"joinApproval"`
+	AttendanceBonusPoints    int        `json:"attendanceBonusPoints"`
+	AttendanceBonusThreshold int        `json:"attendanceBonusThreshold"`
+}
+
+// ExportMember is a member in a group export
+type ExportMember struct {
+	UserID   int       `json:"userId"`
+	Username string    `json:"username"`
+	Role     auth.Role `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// ExportTask is a task in a group export
+type ExportTask struct {
+	ID            int        `json:"id"`
+	CreatorUserID *int       `json:"creatorUserId,omitempty"`
+	CreationDate  time.Time  `json:"creationDate"`
+	DueDate       *time.Time `json:"dueDate"`
+	DueDateOnly   bool       `json:"dueDateOnly"`
+	Priority      string     `json:"priority"`
+	Name          string     `json:"name"`
+	Description   string     `json:"description"`
+	PointsValue   int        `json:"pointsValue"`
+	Step          int        `json:"step"`
+	StateID       int        `json:"stateId"`
+	Rank          string     `json:"rank"`
+	Completed     bool       `json:"completed"`
+}
+
+// ExportTaskEvent is a task event in a group export
+type ExportTaskEvent struct {
+	ID        int             `json:"id"`
+	TaskID    int             `json:"taskId"`
+	UserID    *int            `json:"userId,omitempty"`
+	EventType string          `json:"eventType"`
+	From      json.RawMessage `json:"from,omitempty"`
+	To        json.RawMessage `json:"to,omitempty"`
+}
+
+// ExportAssignee is a member assigned to a task in a group export
+type ExportAssignee struct {
+	TaskID     int       `json:"taskId"`
+	UserID     int       `json:"userId"`
+	AssignedBy *int      `json:"assignedBy,omitempty"`
+	AssignedAt time.Time `json:"assignedAt"`
+}
+
+// ExportTaskPoints is an entry of the points ledger in a group export
+type ExportTaskPoints struct {
+	ID        int       `json:"id"`
+	TaskID    *int      `json:"taskId,omitempty"`
+	UserID    int       `json:"userId"`
+	Points    int       `json:"points"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportWorkflowState is a workflow state in a group export
+type ExportWorkflowState struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Position int    `json:"position"`
+	WIPLimit *int   `json:"wipLimit"`
+	Done     bool   `json:"done"`
+}
+
+// ExportLabel is a label in a group export
+type ExportLabel struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Color     string    `json:"color"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportTaskLabel attaches a label to a task in a group export
+type ExportTaskLabel struct {
+	TaskID  int `json:"taskId"`
+	LabelID int `json:"labelId"`
+}
+
+// ExportOccurrence refers to an occurrence of a meeting in a group export
+type ExportOccurrence struct {
+	MeetingID int       `json:"meetingId"`
+	OccursAt  time.Time `json:"occursAt"`
+}
+
+// ExportRSVP is a member's answer to a meeting occurrence in a group export
+type ExportRSVP struct {
+	ExportOccurrence
+	UserID    int       `json:"userId"`
+	Response  string    `json:"response"`
+	Comment   string    `json:"comment,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ExportAttendance is a member's attendance in a group export
+type ExportAttendance struct {
+	ExportOccurrence
+	UserID   int       `json:"userId"`
+	Attended bool      `json:"attended"`
+	MarkedBy *int      `json:"markedBy,omitempty"`
+	MarkedAt time.Time `json:"markedAt"`
+}
+
+// ExportMeetingBonus is an awarded attendance bonus in a group export
+type ExportMeetingBonus struct {
+	ExportOccurrence
+	Points    int       `json:"points"`
+	AwardedAt time.Time `json:"awardedAt"`
+}
+
+// ExportPollSlot is a candidate time of a poll in a group export
+type ExportPollSlot struct {
+	ID       int       `json:"id"`
+	PollID   int       `json:"pollId"`
+	StartsAt time.Time `json:"startsAt"`
+}
+
+// ExportPollVote is a member's vote for a poll slot in a group export
+type ExportPollVote struct {
+	SlotID    int       `json:"slotId"`
+	UserID    int       `json:"userId"`
+	Vote      string    `json:"vote"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// groupExport is everything a group export contains
+type groupExport struct {
+	Group               GroupExport
+	Members             []ExportMember
+	Tasks               []ExportTask
+	Events              []ExportTaskEvent
+	Assignees           []ExportAssignee
+	TaskPoints          []ExportTaskPoints
+	WorkflowStates      []ExportWorkflowState
+	WorkflowTransitions []WorkflowTransition
+	Labels              []ExportLabel
+	TaskLabels          []ExportTaskLabel
+	Meetings            []Meeting // With their cancelled occurrences
+	RSVPs               []ExportRSVP
+	Attendance          []ExportAttendance
+	MeetingBonuses      []ExportMeetingBonus
+	Polls               []Poll // Without slots, see PollSlots
+	PollSlots           []ExportPollSlot
+	PollVotes           []ExportPollVote
+}
+
+// exportRows runs a query for the rows of a group and scans each of them
+func exportRows[T any](tx *sql.Tx, query string, groupID int, scan func(rows *sql.Rows, v *T) error) ([]T, error) {
+	rows, err := tx.Query(query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := make([]T, 0)
+	for rows.Next() {
+		var v T
+		if err := scan(rows, &v); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// collectGroupExport reads the group's data inside tx
+func collectGroupExport(tx *sql.Tx, groupID int) (*groupExport, error) {
+	exp := &groupExport{}
+
+	var meeting, archivedAt sql.NullTime
+	g := &exp.Group
+	if err := tx.QueryRow(
+		`SELECT id, name, code, points, points_score, meeting, archived_at,
+		        join_approval, attendance_bonus_points, attendance_bonus_threshold
+		   FROM groups
+		  WHERE id = $1`,
+		groupID,
+	).Scan(
+		&g.ID, &g.Name, &g.Code, &g.Points, &g.PointsScore, &meeting, &archivedAt,
+		&g.JoinApproval, &g.AttendanceBonusPoints, &g.AttendanceBonusThreshold,
+	); err != nil {
+		return nil, err
+	}
+	if meeting.Valid {
+		g.Meeting = &meeting.Time
+	}
+	if archivedAt.Valid {
+		g.ArchivedAt = &archivedAt.Time
+	}
+
+	var err error
+	if exp.Members, err = exportRows(tx,
+		`SELECT m.user_id, u.username, m.role, m.joined_at
+		   FROM group_memberships m
+		   JOIN users u ON u.id = m.user_id
+		  WHERE m.group_id = $1
+		  ORDER BY m.joined_at`,
+		groupID, func(rows *sql.Rows, m *ExportMember) error {
+			return rows.Scan(&m.UserID, &m.Username, &m.Role, &m.JoinedAt)
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.Tasks, err = exportRows(tx,
+		`SELECT id, creator_user_id, creation_date, due_date, due_date_only, priority, name,
+		        COALESCE(description, ''), points_value, step, state_id, rank, completed
+		   FROM tasks
+		  WHERE group_id = $1
+		  ORDER BY id`,
+		groupID, func(rows *sql.Rows, t *ExportTask) error {
+			var creator sql.NullInt64
+			err := rows.Scan(
+				&t.ID, &creator, &t.CreationDate, &t.DueDate, &t.DueDateOnly, &t.Priority, &t.Name,
+				&t.Description, &t.PointsValue, &t.Step, &t.StateID, &t.Rank, &t.Completed,
+			)
+			t.CreatorUserID = nullableInt(creator)
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.Events, err = exportRows(tx,
+		`SELECT e.id, e.task_id, e.user_id, e.event_type, e.from_value, e.to_value
+		   FROM task_events e
+		   JOIN tasks t ON t.id = e.task_id
+		  WHERE t.group_id = $1
+		  ORDER BY e.id`,
+		groupID, func(rows *sql.Rows, e *ExportTaskEvent) error {
+			var user sql.NullInt64
+			var from, to []byte
+			err := rows.Scan(&e.ID, &e.TaskID, &user, &e.EventType, &from, &to)
+			e.UserID = nullableInt(user)
+			e.From, e.To = from, to
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.Assignees, err = exportRows(tx,
+		`SELECT a.task_id, a.user_id, a.assigned_by, a.assigned_at
+		   FROM task_assignees a
+		   JOIN tasks t ON t.id = a.task_id
+		  WHERE t.group_id = $1
+		  ORDER BY a.task_id, a.assigned_at`,
+		groupID, func(rows *sql.Rows, a *ExportAssignee) error {
+			var by sql.NullInt64
+			err := rows.Scan(&a.TaskID, &a.UserID, &by, &a.AssignedAt)
+			a.AssignedBy = nullableInt(by)
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.TaskPoints, err = exportRows(tx,
+		`SELECT id, task_id, user_id, points, created_at
+		   FROM task_points
+		  WHERE group_id = $1
+		  ORDER BY id`,
+		groupID, func(rows *sql.Rows, p *ExportTaskPoints) error {
+			var task sql.NullInt64
+			err := rows.Scan(&p.ID, &task, &p.UserID, &p.Points, &p.CreatedAt)
+			p.TaskID = nullableInt(task)
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.WorkflowStates, err = exportRows(tx,
+		`SELECT id, name, color, position, wip_limit, is_done
+		   FROM workflow_states
+		  WHERE group_id = $1
+		  ORDER BY position, id`,
+		groupID, func(rows *sql.Rows, s *ExportWorkflowState) error {
+			var limit sql.NullInt64
+			err := rows.Scan(&s.ID, &s.Name, &s.Color, &s.Position, &limit, &s.Done)
+			s.WIPLimit = nullableInt(limit)
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.WorkflowTransitions, err = exportRows(tx,
+		`SELECT t.from_state_id, t.to_state_id
+		   FROM workflow_transitions t
+		   JOIN workflow_states w ON w.id = t.from_state_id
+		  WHERE w.group_id = $1
+		  ORDER BY t.from_state_id, t.to_state_id`,
+		groupID, func(rows *sql.Rows, t *WorkflowTransition) error {
+			return rows.Scan(&t.From, &t.To)
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.Labels, err = exportRows(tx,
+		`SELECT id, name, color, created_at
+		   FROM labels
+		  WHERE group_id = $1
+		  ORDER BY id`,
+		groupID, func(rows *sql.Rows, l *ExportLabel) error {
+			return rows.Scan(&l.ID, &l.Name, &l.Color, &l.CreatedAt)
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.TaskLabels, err = exportRows(tx,
+		`SELECT tl.task_id, tl.label_id
+		   FROM task_labels tl
+		   JOIN labels l ON l.id = tl.label_id
+		  WHERE l.group_id = $1
+		  ORDER BY tl.task_id, tl.label_id`,
+		groupID, func(rows *sql.Rows, tl *ExportTaskLabel) error {
+			return rows.Scan(&tl.TaskID, &tl.LabelID)
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.Meetings, err = exportRows(tx,
+		`SELECT id, group_id, title, starts_at, duration_minutes, timezone, COALESCE(rrule, ''),
+		        COALESCE(location, ''), COALESCE(link, ''), COALESCE(agenda, ''), created_by, created_at
+		   FROM meetings
+		  WHERE group_id = $1
+		  ORDER BY id`,
+		groupID, func(rows *sql.Rows, m *Meeting) error {
+			var createdBy sql.NullInt64
+			err := rows.Scan(
+				&m.ID, &m.GroupID, &m.Title, &m.StartsAt, &m.DurationMinutes, &m.Timezone, &m.RRule,
+				&m.Location, &m.Link, &m.Agenda, &createdBy, &m.CreatedAt,
+			)
+			m.CreatedBy = nullableInt(createdBy)
+			m.Exceptions = make([]time.Time, 0)
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+	exceptions, err := exportRows(tx,
+		`SELECT e.meeting_id, e.occurs_at
+		   FROM meeting_exceptions e
+		   JOIN meetings m ON m.id = e.meeting_id
+		  WHERE m.group_id = $1
+		  ORDER BY e.occurs_at`,
+		groupID, func(rows *sql.Rows, o *ExportOccurrence) error {
+			return rows.Scan(&o.MeetingID, &o.OccursAt)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range exceptions {
+		for i := range exp.Meetings {
+			if exp.Meetings[i].ID == e.MeetingID {
+				exp.Meetings[i].Exceptions = append(exp.Meetings[i].Exceptions, e.OccursAt)
+			}
+		}
+	}
+
+	if exp.RSVPs, err = exportRows(tx,
+		`SELECT r.meeting_id, r.occurs_at, r.user_id, r.response, COALESCE(r.comment, ''), r.updated_at
+		   FROM meeting_rsvps r
+		   JOIN meetings m ON m.id = r.meeting_id
+		  WHERE m.group_id = $1
+		  ORDER BY r.meeting_id, r.occurs_at, r.user_id`,
+		groupID, func(rows *sql.Rows, v *ExportRSVP) error {
+			return rows.Scan(&v.MeetingID, &v.OccursAt, &v.UserID, &v.Response, &v.Comment, &v.UpdatedAt)
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.Attendance, err = exportRows(tx,
+		`SELECT a.meeting_id, a.occurs_at, a.user_id, a.attended, a.marked_by, a.marked_at
+		   FROM meeting_attendance a
+		   JOIN meetings m ON m.id = a.meeting_id
+		  WHERE m.group_id = $1
+		  ORDER BY a.meeting_id, a.occurs_at, a.user_id`,
+		groupID, func(rows *sql.Rows, a *ExportAttendance) error {
+			var by sql.NullInt64
+			err := rows.Scan(&a.MeetingID, &a.OccursAt, &a.UserID, &a.Attended, &by, &a.MarkedAt)
+			a.MarkedBy = nullableInt(by)
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.MeetingBonuses, err = exportRows(tx,
+		`SELECT b.meeting_id, b.occurs_at, b.points, b.awarded_at
+		   FROM meeting_bonuses b
+		   JOIN meetings m ON m.id = b.meeting_id
+		  WHERE m.group_id = $1
+		  ORDER BY b.meeting_id, b.occurs_at`,
+		groupID, func(rows *sql.Rows, b *ExportMeetingBonus) error {
+			return rows.Scan(&b.MeetingID, &b.OccursAt, &b.Points, &b.AwardedAt)
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.Polls, err = exportRows(tx,
+		`SELECT id, title, duration_minutes, timezone, COALESCE(location, ''), COALESCE(link, ''),
+		        created_by, created_at, closed_at, meeting_id
+		   FROM meeting_polls
+		  WHERE group_id = $1
+		  ORDER BY id`,
+		groupID, func(rows *sql.Rows, p *Poll) error {
+			var createdBy, meetingID sql.NullInt64
+			var closedAt sql.NullTime
+			err := rows.Scan(
+				&p.ID, &p.Title, &p.DurationMinutes, &p.Timezone, &p.Location, &p.Link,
+				&createdBy, &p.CreatedAt, &closedAt, &meetingID,
+			)
+			p.CreatedBy, p.MeetingID = nullableInt(createdBy), nullableInt(meetingID)
+			if closedAt.Valid {
+				p.ClosedAt = &closedAt.Time
+			}
+			p.Slots = make([]PollSlot, 0)
+			return err
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.PollSlots, err = exportRows(tx,
+		`SELECT s.id, s.poll_id, s.starts_at
+		   FROM meeting_poll_slots s
+		   JOIN meeting_polls p ON p.id = s.poll_id
+		  WHERE p.group_id = $1
+		  ORDER BY s.poll_id, s.starts_at`,
+		groupID, func(rows *sql.Rows, s *ExportPollSlot) error {
+			return rows.Scan(&s.ID, &s.PollID, &s.StartsAt)
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	if exp.PollVotes, err = exportRows(tx,
+		`SELECT v.slot_id, v.user_id, v.vote, v.updated_at
+		   FROM meeting_poll_votes v
+		   JOIN meeting_poll_slots s ON s.id = v.slot_id
+		   JOIN meeting_polls p ON p.id = s.poll_id
+		  WHERE p.group_id = $1
+		  ORDER BY v.slot_id, v.user_id`,
+		groupID, func(rows *sql.Rows, v *ExportPollVote) error {
+			return rows.Scan(&v.SlotID, &v.UserID, &v.Vote, &v.UpdatedAt)
+		},
+	); err != nil {
+		return nil, err
+	}
+	return exp, nil
+}
+
+// writeGroupExport writes the export as a ZIP download
+func writeGroupExport(w http.ResponseWriter, exp *groupExport) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="execute-group-`+time.Now().Format("2006-01-02")+`.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"group.json", exp.Group},
+		{"members.json", exp.Members},
+		{"tasks.json", exp.Tasks},
+		{"task_events.json", exp.Events},
+		{"task_assignees.json", exp.Assignees},
+		{"task_points.json", exp.TaskPoints},
+		{"workflow_states.json", exp.WorkflowStates},
+		{"workflow_transitions.json", exp.WorkflowTransitions},
+		{"labels.json", exp.Labels},
+		{"task_labels.json", exp.TaskLabels},
+		{"meetings.json", exp.Meetings},
+		{"meeting_rsvps.json", exp.RSVPs},
+		{"meeting_attendance.json", exp.Attendance},
+		{"meeting_bonuses.json", exp.MeetingBonuses},
+		{"polls.json", exp.Polls},
+		{"poll_slots.json", exp.PollSlots},
+		{"poll_votes.json", exp.PollVotes},
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.v); err != nil {
+			return
+		}
+	}
+}
+
+// ExportGroupHandler handles GET /group/export
+func ExportGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermDeleteGroup) {
+		return
+	}
+
+	// One snapshot, so the files agree with each other
+	tx, err := internal.DB.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Collect everything before writing, so errors can still be reported
+	exp, err := collectGroupExport(tx, groupID)
+	if err != nil {
+		http.Error(w, "Failed to read group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tx.Rollback()
+
+	audit.Record(r, principal.UserID, audit.ActionGroupExport, map[string]any{
+		"groupId": groupID,
+		"members": len(exp.Members),
+		"tasks":   len(exp.Tasks),
+	})
+	writeGroupExport(w, exp)
+}
@@ -4,12 +4,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"execute/internal"
 	"execute/internal/handlers/auth"
-	"execute/internal/handlers/user"
 )
 
 type createReq struct {
@@ -29,17 +29,54 @@ type resp struct {
 	Message string `json:"message"`
 }
 
+type joinResp struct {
+	Message string `json:"message"`
+	GroupID int    `json:"groupId"`
+}
+
+type leaveReq struct {
+	NewOwnerID int `json:"newOwnerId"` // Required when the owner leaves
+}
+
+type activeGroupReq struct {
+	GroupID int `json:"groupId"`
+}
+
+// Membership is a group the current user belongs to
+type Membership struct {
+	GroupID  int       `json:"groupId"`
+	Name     string    `json:"name"`
+	Role     auth.Role `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Active   bool      `json:"active"`
+}
+
 type updateGroupReq struct {
-	Name string `json:"name"`
-	Code string `json:"code,omitempty"`
+	Name         string `json:"name"`
+	Code         string `json:"code,omitempty"`
+	JoinApproval *bool  `json:"joinApproval,omitempty"`
+	// Points the group gets when attendance of a meeting reaches the threshold percentage
+	AttendanceBonusPoints    *int `json:"attendanceBonusPoints,omitempty"`
+	AttendanceBonusThreshold *int `json:"attendanceBonusThreshold,omitempty"`
 }
 
 type groupInfoResp struct {
+	ID          int        `json:"id"`
+	Role        auth.Role  `json:"role"`
 	Name        string     `json:"name"`
 	Code        string     `json:"code"`
 	Points      int        `json:"points"`
 	PointsScore int        `json:"pointsScore"`
 	Meeting     *time.Time `json:"meeting,omitempty"`
+	// NextMeeting is the next occurrence of the group's meetings, if any
+	NextMeeting *Occurrence `json:"nextMeeting,omitempty"`
+	// JoinApproval is set when joining creates a request admins have to accept
+	JoinApproval bool `json:"joinApproval"`
+	// ArchivedAt is set for archived groups, which reject task changes and joins
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+	// AttendanceBonusPoints is 0 when there is no attendance bonus
+	AttendanceBonusPoints    int `json:"attendanceBonusPoints"`
+	AttendanceBonusThreshold int `json:"attendanceBonusThreshold"`
 }
 
 type setMeetingReq struct {
@@ -75,8 +112,15 @@ func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	var id int
-	err = internal.DB.QueryRow(
+	err = tx.QueryRow(
 		`INSERT INTO groups(name, code, creator_user_id)
          VALUES($1,$2,$3) RETURNING id`,
 		req.Name, code, userID,
@@ -86,6 +130,29 @@ func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The creator owns the new group
+	if _, err := tx.Exec(
+		"INSERT INTO group_memberships (group_id, user_id, role) VALUES ($1, $2, $3)",
+		id, userID, auth.RoleOwner,
+	); err != nil {
+		http.Error(w, "Could not add owner: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := internal.CreateDefaultWorkflow(tx, id); err != nil {
+		http.Error(w, "Could not create workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(userID)
+
+	if err := auth.SetActiveGroup(r, id); err != nil && err != auth.ErrNotSession {
+		http.Error(w, "Could not select group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(createResp{ID: id, Code: code})
 }
@@ -113,23 +180,7 @@ func JoinGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var existing sql.NullInt64
-	err = internal.DB.QueryRow(
-		"SELECT group_id FROM users WHERE id = $1", userID,
-	).Scan(&existing)
-	if err != nil {
-		http.Error(w, "User lookup failed: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if existing.Valid {
-		http.Error(w, "You are already in a group", http.StatusConflict)
-		return
-	}
-
-	var groupID int
-	err = internal.DB.QueryRow(
-		"SELECT id FROM groups WHERE code = $1", req.Code,
-	).Scan(&groupID)
+	groupID, inviteID, err := lookupJoinCode(req.Code)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Invalid join code", http.StatusNotFound)
 		return
@@ -138,28 +189,55 @@ func JoinGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = internal.DB.Exec(
-		"UPDATE users SET group_id = $1 WHERE id = $2",
-		groupID, userID,
-	)
-	if err != nil {
+	var grant grantFunc
+	if inviteID != 0 {
+		grant = func(tx *sql.Tx) (auth.Role, error) { return useInvite(tx, inviteID) }
+	}
+	pending, err := joinGroup(groupID, userID, grant)
+	writeJoinResult(w, r, groupID, pending, err)
+}
+
+// writeJoinResult answers a join attempt and selects the joined group
+func writeJoinResult(w http.ResponseWriter, r *http.Request, groupID int, pending bool, err error) {
+	switch {
+	case err == errBanned:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err == errInviteRevoked || err == errInviteExpired || err == errInviteUsedUp:
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err == errAlreadyMember || err == errAlreadyRequested || err == ErrArchived:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
 		http.Error(w, "Could not join group: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if pending {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(joinResp{Message: "Join request sent, an admin has to approve it", GroupID: groupID})
+		return
+	}
+
+	// Browser sessions switch to the group they just joined
+	if err := auth.SetActiveGroup(r, groupID); err != nil && err != auth.ErrNotSession {
+		http.Error(w, "Could not select group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(resp{Message: "Joined group successfully"})
+	json.NewEncoder(w).Encode(joinResp{Message: "Joined group successfully", GroupID: groupID})
 }
 
 // UpdateGroupHandler handles PUT /group
 func UpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, "user not authenticated", http.StatusUnauthorized)
 		return
 	}
 
-	groupID, err := user.GetUserGroupID(userID)
+	groupID, err := principal.Group()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -176,18 +254,45 @@ func UpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var query string
-	var args []any
+	if !auth.RequirePermission(w, principal, groupID, auth.PermEditGroup) {
+		return
+	}
+	if req.Code != "" && !auth.RequirePermission(w, principal, groupID, auth.PermChangeCode) {
+		return
+	}
+	if req.JoinApproval != nil && !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+	if req.AttendanceBonusPoints != nil || req.AttendanceBonusThreshold != nil {
+		if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+			return
+		}
+		if p := req.AttendanceBonusPoints; p != nil && (*p < 0 || *p > maxAttendanceBonus) {
+			http.Error(w, "attendanceBonusPoints must be between 0 and 1000", http.StatusBadRequest)
+			return
+		}
+		if t := req.AttendanceBonusThreshold; t != nil && (*t < 1 || *t > 100) {
+			http.Error(w, "attendanceBonusThreshold must be a percentage between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
 
-	if req.Code != "" {
-		query = `UPDATE groups SET name = $1, code = $2 WHERE id = $3 AND creator_user_id = $4`
-		args = []any{req.Name, req.Code, groupID, userID}
-	} else {
-		query = `UPDATE groups SET name = $1 WHERE id = $2 AND creator_user_id = $3`
-		args = []any{req.Name, groupID, userID}
+	// A new join code needs a recently authenticated session
+	if req.Code != "" && !auth.RequireElevated(w, r) {
+		return
 	}
 
-	result, err := internal.DB.Exec(query, args...)
+	// Missing optional fields keep their current value
+	result, err := internal.DB.Exec(
+		`UPDATE groups
+		    SET name = $1,
+		        code = COALESCE(NULLIF($2, ''), code),
+		        join_approval = COALESCE($3, join_approval),
+		        attendance_bonus_points = COALESCE($4, attendance_bonus_points),
+		        attendance_bonus_threshold = COALESCE($5, attendance_bonus_threshold)
+		  WHERE id = $6`,
+		req.Name, req.Code, req.JoinApproval, req.AttendanceBonusPoints, req.AttendanceBonusThreshold, groupID,
+	)
 	if err != nil {
 		if internal.IsUniqueViolation(err) {
 			http.Error(w, "group code already in use", http.StatusConflict)
@@ -199,7 +304,7 @@ func UpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		http.Error(w, "no permission to update group or group not found", http.StatusForbidden)
+		http.Error(w, "group not found", http.StatusNotFound)
 		return
 	}
 
@@ -215,35 +320,82 @@ func LeaveGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	userID := principal.UserID
 
-	// Check that user is actually in a group
-	var existing sql.NullInt64
-	err = internal.DB.QueryRow(
-		"SELECT group_id FROM users WHERE id = $1",
-		userID,
-	).Scan(&existing)
+	// Leaves the selected group, see auth.Principal.Group
+	groupID, err := principal.Group()
+	if err == auth.ErrNoGroup {
+		http.Error(w, "You are not in a group", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, "User lookup failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	if !existing.Valid {
+
+	var req leaveReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	role, err := memberRole(tx, groupID, userID)
+	if err == sql.ErrNoRows {
 		http.Error(w, "You are not in a group", http.StatusConflict)
 		return
+	} else if err != nil {
+		http.Error(w, "Role lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	_, err = internal.DB.Exec(
-		"UPDATE users SET group_id = NULL WHERE id = $1",
-		userID,
-	)
-	if err != nil {
+	// A group is never left without an owner
+	if role == auth.RoleOwner {
+		if req.NewOwnerID == 0 {
+			http.Error(w, "The owner must hand over the group before leaving, pass newOwnerId", http.StatusConflict)
+			return
+		}
+		if !auth.RequireElevated(w, r) {
+			return
+		}
+		if err := transferOwnership(tx, groupID, userID, req.NewOwnerID); err != nil {
+			writeTransferError(w, err)
+			return
+		}
+	}
+
+	if _, err := tx.Exec(
+		"DELETE FROM group_memberships WHERE group_id = $1 AND user_id = $2",
+		groupID, userID,
+	); err != nil {
+		http.Error(w, "Could not leave group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := unassignOpenTasks(tx, groupID, userID); err != nil {
 		http.Error(w, "Could not leave group: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(userID)
+	if role == auth.RoleOwner {
+		auth.InvalidatePrincipal(req.NewOwnerID)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp{Message: "Left group successfully"})
@@ -251,13 +403,13 @@ func LeaveGroupHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetGroupInfoHandler handles GET /group/info
 func GetGroupInfoHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	groupID, err := user.GetUserGroupID(userID)
+	groupID, err := principal.Group()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -265,53 +417,71 @@ func GetGroupInfoHandler(w http.ResponseWriter, r *http.Request) {
 
 	var name, code string
 	var points, pointsScore int
-	var meeting sql.NullTime
+	var meeting, archivedAt sql.NullTime
+	var joinApproval bool
+	var bonusPoints, bonusThreshold int
 	err = internal.DB.QueryRow(
-		`SELECT name, code, points, points_score, meeting FROM groups WHERE id = $1`, groupID,
-	).Scan(&name, &code, &points, &pointsScore, &meeting)
+		`SELECT name, code, points, points_score, meeting, join_approval, archived_at,
+		        attendance_bonus_points, attendance_bonus_threshold
+		   FROM groups WHERE id = $1`, groupID,
+	).Scan(&name, &code, &points, &pointsScore, &meeting, &joinApproval, &archivedAt, &bonusPoints, &bonusThreshold)
 	if err != nil {
 		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	resp := groupInfoResp{
-		Name:        name,
-		Code:        code,
-		Points:      points,
-		PointsScore: pointsScore,
+		ID:                       groupID,
+		Role:                     principal.RoleIn(groupID),
+		Name:                     name,
+		Code:                     code,
+		Points:                   points,
+		PointsScore:              pointsScore,
+		JoinApproval:             joinApproval,
+		AttendanceBonusPoints:    bonusPoints,
+		AttendanceBonusThreshold: bonusThreshold,
 	}
 	if meeting.Valid {
 		resp.Meeting = &meeting.Time
 	}
 
+	// meeting reports the next meeting, the time set with POST /group/meeting
+	// counts as long as it is ahead of the scheduled ones
+	now := time.Now()
+	next, err := nextOccurrence(groupID, now)
+	if err != nil {
+		http.Error(w, "Meeting lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if next != nil {
+		resp.NextMeeting = next
+		if !meeting.Valid || meeting.Time.Before(now) || next.Start.Before(meeting.Time) {
+			resp.Meeting = &next.Start
+		}
+	}
+	if archivedAt.Valid {
+		resp.ArchivedAt = &archivedAt.Time
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
 // SetGroupMeetingHandler handles POST /group/meeting
 func SetGroupMeetingHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, "user not authenticated", http.StatusUnauthorized)
 		return
 	}
 
-	groupID, err := user.GetUserGroupID(userID)
+	groupID, err := principal.Group()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	var isCreator bool
-	err = internal.DB.QueryRow(
-		`SELECT creator_user_id = $1 FROM groups WHERE id = $2`, userID, groupID,
-	).Scan(&isCreator)
-	if err != nil {
-		http.Error(w, "group lookup failed: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if !isCreator {
-		http.Error(w, "only group creator can set the meeting", http.StatusForbidden)
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
 		return
 	}
 
@@ -333,3 +503,81 @@ func SetGroupMeetingHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp{Message: "Meeting time updated successfully"})
 }
+
+// MembershipsHandler handles GET /group/memberships
+func MembershipsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT g.id, g.name, m.role, m.joined_at
+		   FROM group_memberships m
+		   JOIN groups g ON g.id = m.group_id
+		  WHERE m.user_id = $1
+		  ORDER BY m.joined_at, g.id`,
+		principal.UserID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to query memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	memberships := make([]Membership, 0)
+	for rows.Next() {
+		var m Membership
+		if err := rows.Scan(&m.GroupID, &m.Name, &m.Role, &m.JoinedAt); err != nil {
+			http.Error(w, "Failed to scan membership: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.Active = m.GroupID == principal.GroupID
+		memberships = append(memberships, m)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Error iterating over memberships: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(memberships)
+}
+
+// SetActiveGroupHandler handles PUT /group/active
+func SetActiveGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req activeGroupReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !principal.IsMember(req.GroupID) {
+		http.Error(w, auth.ErrNotMember.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := auth.SetActiveGroup(r, req.GroupID); err != nil {
+		http.Error(w, "Could not select group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(joinResp{Message: "Active group updated", GroupID: req.GroupID})
+}
@@ -0,0 +1,343 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+var errInvitationGone = errors.New("this invitation no longer exists")
+
+type createInvitationReq struct {
+	Username      string `json:"username"`
+	Role          string `json:"role"`          // Defaults to member
+	ExpiresInDays int    `json:"expiresInDays"` // Defaults to 7
+}
+
+// Invitation is a pending invitation of a specific user into a group
+type Invitation struct {
+	ID            int       `json:"id"`
+	GroupID       int       `json:"groupId"`
+	GroupName     string    `json:"groupName,omitempty"`
+	UserID        int       `json:"userId"`
+	Username      string    `json:"username"`
+	Role          auth.Role `json:"role"`
+	InvitedBy     *int      `json:"invitedBy,omitempty"`
+	InvitedByName string    `json:"invitedByName,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+// useInvitation consumes the user's invitation and returns the role it grants
+func useInvitation(tx *sql.Tx, invitationID, userID int) (auth.Role, error) {
+	var role auth.Role
+	var expired bool
+	err := tx.QueryRow(
+		`DELETE FROM invitations
+		  WHERE id = $1 AND user_id = $2
+		  RETURNING role, expires_at <= NOW()`,
+		invitationID, userID,
+	).Scan(&role, &expired)
+	if err == sql.ErrNoRows {
+		return "", errInvitationGone
+	} else if err != nil {
+		return "", err
+	}
+	if expired {
+		return "", errInviteExpired
+	}
+	return role, nil
+}
+
+// queryInvitations lists invitations matching the condition on i, with the
+// group and user names filled in
+func queryInvitations(where string, args ...any) ([]Invitation, error) {
+	rows, err := internal.DB.Query(
+		`SELECT i.id, i.group_id, g.name, i.user_id, u.username, i.role,
+		        i.invited_by, COALESCE(ib.username, ''), i.created_at, i.expires_at
+		   FROM invitations i
+		   JOIN groups g ON g.id = i.group_id
+		   JOIN users u ON u.id = i.user_id
+		   LEFT JOIN users ib ON ib.id = i.invited_by
+		  WHERE `+where+`
+		  ORDER BY i.created_at DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := make([]Invitation, 0)
+	for rows.Next() {
+		var inv Invitation
+		var invitedBy sql.NullInt64
+		if err := rows.Scan(
+			&inv.ID, &inv.GroupID, &inv.GroupName, &inv.UserID, &inv.Username, &inv.Role,
+			&invitedBy, &inv.InvitedByName, &inv.CreatedAt, &inv.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		if invitedBy.Valid {
+			id := int(invitedBy.Int64)
+			inv.InvitedBy = &id
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// ListGroupInvitationsHandler handles GET /group/invitations
+func ListGroupInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	invitations, err := queryInvitations("i.group_id = $1", groupID)
+	if err != nil {
+		http.Error(w, "Failed to query invitations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// CreateInvitationHandler handles POST /group/invitations
+func CreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	var req createInvitationReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	role, ok := parseInviteRole(w, principal, groupID, req.Role)
+	if !ok {
+		return
+	}
+	if !validInviteDays(&req.ExpiresInDays) {
+		http.Error(w, "expiresInDays must be between 1 and 90", http.StatusBadRequest)
+		return
+	}
+
+	inv := Invitation{GroupID: groupID, Username: req.Username, Role: role, InvitedBy: &principal.UserID}
+	var isMember, banned bool
+	err = internal.DB.QueryRow(
+		`SELECT u.id,
+		        EXISTS (SELECT 1 FROM group_memberships WHERE group_id = $2 AND user_id = u.id),
+		        EXISTS (SELECT 1 FROM group_bans WHERE group_id = $2 AND user_id = u.id)
+		   FROM users u
+		  WHERE u.username = $1`,
+		req.Username, groupID,
+	).Scan(&inv.UserID, &isMember, &banned)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "User lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isMember {
+		http.Error(w, "User is already in this group", http.StatusConflict)
+		return
+	}
+	if banned {
+		http.Error(w, "User is banned from this group, unban them first", http.StatusConflict)
+		return
+	}
+
+	// An expired invitation is replaced, a pending one is left alone
+	err = internal.DB.QueryRow(
+		`INSERT INTO invitations (group_id, user_id, invited_by, role, expires_at)
+		 VALUES ($1, $2, $3, $4, NOW() + make_interval(days => $5))
+		 ON CONFLICT (group_id, user_id) DO UPDATE
+		    SET invited_by = EXCLUDED.invited_by, role = EXCLUDED.role,
+		        created_at = NOW(), expires_at = EXCLUDED.expires_at
+		  WHERE invitations.expires_at <= NOW()
+		 RETURNING id, created_at, expires_at`,
+		groupID, inv.UserID, principal.UserID, role, req.ExpiresInDays,
+	).Scan(&inv.ID, &inv.CreatedAt, &inv.ExpiresAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User already has a pending invitation", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not create invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// CancelInvitationHandler handles DELETE /group/invitations/{id}
+func CancelInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	invitationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := internal.DB.Exec(
+		"DELETE FROM invitations WHERE id = $1 AND group_id = $2",
+		invitationID, groupID,
+	)
+	if err != nil {
+		http.Error(w, "Could not cancel invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Invitation cancelled"})
+}
+
+// InboxHandler handles GET /invitations
+func InboxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	invitations, err := queryInvitations("i.user_id = $1 AND i.expires_at > NOW()", userID)
+	if err != nil {
+		http.Error(w, "Failed to query invitations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// AcceptInvitationHandler handles POST /invitations/{id}/accept
+func AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	invitationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	var groupID int
+	err = internal.DB.QueryRow(
+		"SELECT group_id FROM invitations WHERE id = $1 AND user_id = $2",
+		invitationID, userID,
+	).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Lookup error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pending, err := joinGroup(groupID, userID, func(tx *sql.Tx) (auth.Role, error) {
+		return useInvitation(tx, invitationID, userID)
+	})
+	if err == errInvitationGone {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJoinResult(w, r, groupID, pending, err)
+}
+
+// DeclineInvitationHandler handles DELETE /invitations/{id}
+func DeclineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	invitationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := internal.DB.Exec(
+		"DELETE FROM invitations WHERE id = $1 AND user_id = $2",
+		invitationID, userID,
+	)
+	if err != nil {
+		http.Error(w, "Could not decline invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Invitation declined"})
+}
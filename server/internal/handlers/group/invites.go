@@ -0,0 +1,372 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+	"execute/internal/mail"
+)
+
+const (
+	inviteDefaultDays = 7
+	inviteMaxDays     = 90
+	inviteMaxPerGroup = 50
+)
+
+var (
+	errInviteRevoked = errors.New("this invite has been revoked")
+	errInviteExpired = errors.New("this invite has expired")
+	errInviteUsedUp  = errors.New("this invite has reached its maximum number of uses")
+)
+
+type createInviteReq struct {
+	Role          string `json:"role"`          // Defaults to member
+	MaxUses       int    `json:"maxUses"`       // 0 means unlimited
+	ExpiresInDays int    `json:"expiresInDays"` // Defaults to 7
+}
+
+// Invite is a link that lets users join a group
+type Invite struct {
+	ID        int        `json:"id"`
+	Code      string     `json:"code"`
+	URL       string     `json:"url"`
+	Role      auth.Role  `json:"role"`
+	MaxUses   *int       `json:"maxUses,omitempty"`
+	UseCount  int        `json:"useCount"`
+	CreatedBy *int       `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	Active    bool       `json:"active"`
+}
+
+// inviteURL is the link to the app's join page for an invite code
+func inviteURL(code string) string {
+	return mail.AppURL("/join?code=" + url.QueryEscape(code))
+}
+
+// parseInviteRole validates the role an invite gives, defaulting to member.
+// Invites can only hand out roles the creator could assign themselves.
+// On failure the response has been written.
+func parseInviteRole(w http.ResponseWriter, p *auth.Principal, groupID int, name string) (auth.Role, bool) {
+	if name == "" {
+		name = string(auth.RoleMember)
+	}
+	role, ok := auth.ParseRole(name)
+	if !ok || role == auth.RoleOwner {
+		http.Error(w, "role must be admin, member or viewer", http.StatusBadRequest)
+		return "", false
+	}
+	if !p.RoleIn(groupID).Outranks(role) {
+		http.Error(w, "Your role cannot invite members with this role", http.StatusForbidden)
+		return "", false
+	}
+	return role, true
+}
+
+// validInviteDays checks the lifetime of an invite, defaulting to 7 days
+func validInviteDays(days *int) bool {
+	if *days == 0 {
+		*days = inviteDefaultDays
+	}
+	return *days >= 1 && *days <= inviteMaxDays
+}
+
+// lookupJoinCode resolves a join code to its group. Invite codes are checked
+// first, the group's own code keeps working with inviteID 0.
+func lookupJoinCode(code string) (groupID, inviteID int, err error) {
+	err = internal.DB.QueryRow(
+		"SELECT group_id, id FROM invites WHERE code = $1", code,
+	).Scan(&groupID, &inviteID)
+	if err != sql.ErrNoRows {
+		return groupID, inviteID, err
+	}
+	err = internal.DB.QueryRow(
+		"SELECT id FROM groups WHERE code = $1", code,
+	).Scan(&groupID)
+	return groupID, 0, err
+}
+
+// useInvite checks that the invite can still be used, counts the use and
+// returns the role it grants. The invite row stays locked until tx ends.
+func useInvite(tx *sql.Tx, inviteID int) (auth.Role, error) {
+	var role auth.Role
+	var maxUses sql.NullInt64
+	var useCount int
+	var expired bool
+	var revokedAt sql.NullTime
+	if err := tx.QueryRow(
+		`SELECT role, max_uses, use_count, expires_at <= NOW(), revoked_at
+		   FROM invites
+		  WHERE id = $1
+		    FOR UPDATE`,
+		inviteID,
+	).Scan(&role, &maxUses, &useCount, &expired, &revokedAt); err != nil {
+		return "", err
+	}
+
+	switch {
+	case revokedAt.Valid:
+		return "", errInviteRevoked
+	case expired:
+		return "", errInviteExpired
+	case maxUses.Valid && int64(useCount) >= maxUses.Int64:
+		return "", errInviteUsedUp
+	}
+
+	if _, err := tx.Exec("UPDATE invites SET use_count = use_count + 1 WHERE id = $1", inviteID); err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// scanInvite reads an invite row selected with inviteColumns
+func scanInvite(row interface{ Scan(...any) error }) (Invite, error) {
+	var inv Invite
+	var maxUses, createdBy sql.NullInt64
+	var revokedAt sql.NullTime
+	if err := row.Scan(
+		&inv.ID, &inv.Code, &inv.Role, &maxUses, &inv.UseCount,
+		&createdBy, &inv.CreatedAt, &inv.ExpiresAt, &revokedAt,
+	); err != nil {
+		return inv, err
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		inv.MaxUses = &n
+	}
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		inv.CreatedBy = &id
+	}
+	if revokedAt.Valid {
+		inv.RevokedAt = &revokedAt.Time
+	}
+	inv.URL = inviteURL(inv.Code)
+	inv.Active = inv.RevokedAt == nil && time.Now().Before(inv.ExpiresAt) &&
+		(inv.MaxUses == nil || inv.UseCount < *inv.MaxUses)
+	return inv, nil
+}
+
+const inviteColumns = `id, code, role, max_uses, use_count, created_by, created_at, expires_at, revoked_at`
+
+// ListInvitesHandler handles GET /group/invites
+func ListInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT `+inviteColumns+`
+		   FROM invites
+		  WHERE group_id = $1
+		  ORDER BY created_at DESC`,
+		groupID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to query invites: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invites := make([]Invite, 0)
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			http.Error(w, "Failed to scan invite: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		invites = append(invites, inv)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Error iterating over invites: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invites)
+}
+
+// CreateInviteHandler handles POST /group/invites
+func CreateInviteHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	var req createInviteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role, ok := parseInviteRole(w, principal, groupID, req.Role)
+	if !ok {
+		return
+	}
+	if req.MaxUses < 0 {
+		http.Error(w, "maxUses must not be negative", http.StatusBadRequest)
+		return
+	}
+	if !validInviteDays(&req.ExpiresInDays) {
+		http.Error(w, "expiresInDays must be between 1 and 90", http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if err := internal.DB.QueryRow(
+		`SELECT COUNT(*) FROM invites
+		  WHERE group_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`,
+		groupID,
+	).Scan(&count); err != nil {
+		http.Error(w, "Failed to count invites: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count >= inviteMaxPerGroup {
+		http.Error(w, "Too many active invites, revoke some first", http.StatusConflict)
+		return
+	}
+
+	code, err := NewInviteCode()
+	if err != nil {
+		http.Error(w, "Failed to generate code", http.StatusInternalServerError)
+		return
+	}
+
+	inv, err := scanInvite(internal.DB.QueryRow(
+		`INSERT INTO invites (group_id, code, created_by, role, max_uses, expires_at)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, 0), NOW() + make_interval(days => $6))
+		 RETURNING `+inviteColumns,
+		groupID, code, principal.UserID, role, req.MaxUses, req.ExpiresInDays,
+	))
+	if err != nil {
+		http.Error(w, "Could not create invite: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// RevokeInviteHandler handles DELETE /group/invites/{id}
+func RevokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	inviteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid invite ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := internal.DB.Exec(
+		`UPDATE invites SET revoked_at = NOW()
+		  WHERE id = $1 AND group_id = $2 AND revoked_at IS NULL`,
+		inviteID, groupID,
+	)
+	if err != nil {
+		http.Error(w, "Could not revoke invite: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Invite revoked"})
+}
+
+// InviteQRHandler handles GET /group/invites/{id}/qr
+func InviteQRHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	inviteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid invite ID", http.StatusBadRequest)
+		return
+	}
+
+	var code string
+	err = internal.DB.QueryRow(
+		"SELECT code FROM invites WHERE id = $1 AND group_id = $2", inviteID, groupID,
+	).Scan(&code)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Lookup error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	png, err := qrcode.Encode(inviteURL(code), qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Failed to render QR code: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
@@ -0,0 +1,312 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+const labelMaxName = 30
+
+type labelReq struct {
+	Name  string `json:"name"`
+	Color string `json:"color"` // Defaults to grey
+}
+
+// Label tags the group's tasks
+type Label struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	Tasks int    `json:"tasks"`
+}
+
+// validate normalises the request. It returns a message for invalid input.
+func (req *labelReq) validate() string {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > labelMaxName {
+		return "name is required and must be at most 30 characters"
+	}
+	if req.Color == "" {
+		req.Color = workflowDefaultColor
+	}
+	if !workflowColor.MatchString(req.Color) {
+		return "color must be a hex colour like #3b82f6"
+	}
+	return ""
+}
+
+func labelID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid label ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// loadLabel returns a label of the group with its number of tasks
+func loadLabel(groupID, id int) (*Label, error) {
+	l := &Label{}
+	err := internal.DB.QueryRow(
+		`SELECT id, name, color, (SELECT COUNT(*) FROM task_labels WHERE label_id = l.id)
+		   FROM labels l
+		  WHERE id = $1 AND group_id = $2`,
+		id, groupID,
+	).Scan(&l.ID, &l.Name, &l.Color, &l.Tasks)
+	return l, err
+}
+
+// ListLabelsHandler handles GET /group/labels
+func ListLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT id, name, color, (SELECT COUNT(*) FROM task_labels WHERE label_id = l.id)
+		   FROM labels l
+		  WHERE group_id = $1
+		  ORDER BY lower(name)`,
+		groupID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to load labels: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	labels := make([]Label, 0)
+	for rows.Next() {
+		var l Label
+		if err := rows.Scan(&l.ID, &l.Name, &l.Color, &l.Tasks); err != nil {
+			http.Error(w, "Failed to load labels: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		labels = append(labels, l)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to load labels: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(labels)
+}
+
+// CreateLabelHandler handles POST /group/labels
+func CreateLabelHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermEditGroup) {
+		return
+	}
+
+	var req labelReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	archived, err := Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	var id int
+	err = tx.QueryRow(
+		"INSERT INTO labels (group_id, name, color) VALUES ($1, $2, $3) RETURNING id",
+		groupID, req.Name, req.Color,
+	).Scan(&id)
+	if internal.IsUniqueViolation(err) {
+		http.Error(w, "A label with that name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not create label: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Label{ID: id, Name: req.Name, Color: req.Color})
+}
+
+// UpdateLabelHandler handles PUT /group/labels/{id}
+func UpdateLabelHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermEditGroup) {
+		return
+	}
+
+	id, ok := labelID(w, r)
+	if !ok {
+		return
+	}
+
+	var req labelReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	archived, err := Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	result, err := tx.Exec(
+		"UPDATE labels SET name = $1, color = $2 WHERE id = $3 AND group_id = $4",
+		req.Name, req.Color, id, groupID,
+	)
+	if internal.IsUniqueViolation(err) {
+		http.Error(w, "A label with that name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not update label: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Label not found", http.StatusNotFound)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	l, err := loadLabel(groupID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Label not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to load label: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(l)
+}
+
+// DeleteLabelHandler handles DELETE /group/labels/{id}
+func DeleteLabelHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermEditGroup) {
+		return
+	}
+
+	id, ok := labelID(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	archived, err := Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	// The label comes off its tasks with it
+	result, err := tx.Exec("DELETE FROM labels WHERE id = $1 AND group_id = $2", id, groupID)
+	if err != nil {
+		http.Error(w, "Could not delete label: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Label not found", http.StatusNotFound)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Label deleted"})
+}
@@ -0,0 +1,268 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"execute/internal"
+	"execute/internal/audit"
+	"execute/internal/handlers/auth"
+)
+
+const groupDeleteTokenDuration = 10 * time.Minute
+
+var (
+	ErrArchived           = errors.New("this group is archived")
+	errInvalidDeleteToken = errors.New("invalid or expired confirmation token, request a new one")
+)
+
+type deleteGroupReq struct {
+	Token  string `json:"token"`
+	Export bool   `json:"export"` // Respond with a ZIP export of the group
+}
+
+type deleteTokenResp struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type archiveResp struct {
+	Message    string     `json:"message"`
+	GroupID    int        `json:"groupId"`
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+}
+
+// groupArchived reports whether the group is archived, locking its row
+func Archived(tx *sql.Tx, groupID int) (bool, error) {
+	var archived bool
+	err := tx.QueryRow(
+		"SELECT archived_at IS NOT NULL FROM groups WHERE id = $1 FOR UPDATE", groupID,
+	).Scan(&archived)
+	return archived, err
+}
+
+// nullableInt converts a nullable column to a pointer
+func nullableInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+// DeleteTokenHandler handles POST /group/delete-token
+func DeleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermDeleteGroup) {
+		return
+	}
+
+	token, err := auth.GenerateSessionToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	var res deleteTokenResp
+	res.Token = token
+	if err := internal.DB.QueryRow(
+		`INSERT INTO group_delete_tokens (group_id, user_id, token_hash, expires_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (group_id) DO UPDATE
+		    SET user_id = EXCLUDED.user_id, token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at
+		 RETURNING expires_at`,
+		groupID, principal.UserID, auth.HashToken(token), time.Now().Add(groupDeleteTokenDuration),
+	).Scan(&res.ExpiresAt); err != nil {
+		http.Error(w, "Could not store token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// DeleteGroupHandler handles DELETE /group
+func DeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermDeleteGroup) {
+		return
+	}
+	if !auth.RequireElevated(w, r) {
+		return
+	}
+
+	var req deleteGroupReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "token is required, see POST /group/delete-token", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := Archived(tx, groupID); err != nil {
+		http.Error(w, "Failed to lock group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The role is read from the database, ownership may have moved since
+	role, err := memberRole(tx, groupID, userID)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Role lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !role.Has(auth.PermDeleteGroup) {
+		http.Error(w, "Your role in this group does not allow this", http.StatusForbidden)
+		return
+	}
+
+	result, err := tx.Exec(
+		`DELETE FROM group_delete_tokens
+		  WHERE group_id = $1 AND user_id = $2 AND token_hash = $3 AND expires_at > NOW()`,
+		groupID, userID, auth.HashToken(req.Token),
+	)
+	if err != nil {
+		http.Error(w, "Token check failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, errInvalidDeleteToken.Error(), http.StatusForbidden)
+		return
+	}
+
+	exp, err := collectGroupExport(tx, groupID)
+	if err != nil {
+		http.Error(w, "Failed to read group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := internal.DeleteGroup(tx, groupID); err != nil {
+		http.Error(w, "Could not delete group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := audit.RecordTx(tx, r, userID, audit.ActionGroupDelete, map[string]any{
+		"groupId": groupID,
+		"name":    exp.Group.Name,
+		"members": len(exp.Members),
+		"tasks":   len(exp.Tasks),
+		"export":  req.Export,
+	}); err != nil {
+		http.Error(w, "Could not write audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	for _, m := range exp.Members {
+		auth.InvalidatePrincipal(m.UserID)
+	}
+
+	if req.Export {
+		writeGroupExport(w, exp)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Group deleted"})
+}
+
+// ArchiveGroupHandler handles POST and DELETE /group/archive
+func ArchiveGroupHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermDeleteGroup) {
+		return
+	}
+
+	archive := r.Method == http.MethodPost
+	query := "UPDATE groups SET archived_at = NOW() WHERE id = $1 AND archived_at IS NULL RETURNING archived_at"
+	if !archive {
+		query = "UPDATE groups SET archived_at = NULL WHERE id = $1 AND archived_at IS NOT NULL RETURNING archived_at"
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var archivedAt sql.NullTime
+	err = tx.QueryRow(query, groupID).Scan(&archivedAt)
+	if err == sql.ErrNoRows {
+		if archive {
+			http.Error(w, "Group is already archived", http.StatusConflict)
+		} else {
+			http.Error(w, "Group is not archived", http.StatusConflict)
+		}
+		return
+	} else if err != nil {
+		http.Error(w, "Could not update group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := audit.RecordTx(tx, r, principal.UserID, audit.ActionGroupArchive, map[string]any{
+		"groupId":  groupID,
+		"archived": archive,
+	}); err != nil {
+		http.Error(w, "Could not write audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	res := archiveResp{Message: "Group unarchived", GroupID: groupID}
+	if archive {
+		res.Message = "Group archived"
+		res.ArchivedAt = &archivedAt.Time
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,557 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Meeting timezones must resolve on hosts without a zoneinfo database
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+	"execute/internal/rrule"
+)
+
+const (
+	meetingDefaultMinutes = 60
+	meetingMaxMinutes     = 24 * 60
+	meetingMaxTitle       = 200
+	meetingMaxExceptions  = 500
+	occurrenceMaxRange    = 366 * 24 * time.Hour
+	occurrenceDefaultDays = 30
+	// nextMeetingHorizon is how far ahead GET /group/info looks for the next meeting
+	nextMeetingHorizon = 2 * 366 * 24 * time.Hour
+)
+
+type meetingReq struct {
+	Title           string      `json:"title"`
+	StartsAt        time.Time   `json:"startsAt"`
+	DurationMinutes int         `json:"durationMinutes"`
+	Timezone        string      `json:"timezone"`
+	RRule           string      `json:"rrule"`      // Empty for a one-off meeting
+	Exceptions      []time.Time `json:"exceptions"` // Cancelled occurrences
+	Location        string      `json:"location"`
+	Link            string      `json:"link"`
+	Agenda          string      `json:"agenda"`
+}
+
+// Meeting is a one-off or recurring meeting of a group
+type Meeting struct {
+	ID              int         `json:"id"`
+	GroupID         int         `json:"groupId"`
+	Title           string      `json:"title"`
+	StartsAt        time.Time   `json:"startsAt"`
+	DurationMinutes int         `json:"durationMinutes"`
+	Timezone        string      `json:"timezone"`
+	RRule           string      `json:"rrule,omitempty"`
+	Exceptions      []time.Time `json:"exceptions"`
+	Location        string      `json:"location,omitempty"`
+	Link            string      `json:"link,omitempty"`
+	Agenda          string      `json:"agenda,omitempty"`
+	CreatedBy       *int        `json:"createdBy,omitempty"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+// Occurrence is a single meeting of a schedule
+type Occurrence struct {
+	MeetingID int       `json:"meetingId"`
+	Title     string    `json:"title"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Timezone  string    `json:"timezone"`
+	Location  string    `json:"location,omitempty"`
+	Link      string    `json:"link,omitempty"`
+}
+
+// validate normalises the request. It returns a message for invalid input.
+func (req *meetingReq) validate() string {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" || len(req.Title) > meetingMaxTitle {
+		return "title is required and must be at most 200 characters"
+	}
+	if req.StartsAt.IsZero() {
+		return "startsAt is required"
+	}
+	if req.DurationMinutes == 0 {
+		req.DurationMinutes = meetingDefaultMinutes
+	}
+	if req.DurationMinutes < 1 || req.DurationMinutes > meetingMaxMinutes {
+		return "durationMinutes must be between 1 and 1440"
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		return "unknown timezone " + req.Timezone
+	}
+	if req.RRule != "" {
+		rule, err := rrule.Parse(req.RRule)
+		if err != nil {
+			return "invalid rrule: " + err.Error()
+		}
+		req.RRule = rule.String()
+	}
+	if len(req.Exceptions) > meetingMaxExceptions {
+		return "too many exceptions"
+	}
+	if req.Link != "" {
+		u, err := url.Parse(req.Link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "link must be an http or https URL"
+		}
+	}
+	return ""
+}
+
+// Occurrences returns the starts of the meeting in [from, to) without the
+// cancelled ones, at most limit of them if limit is positive
+func (m *Meeting) Occurrences(from, to time.Time, limit int) ([]time.Time, error) {
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	start := m.StartsAt.In(loc)
+
+	starts := []time.Time{start}
+	if m.RRule != "" {
+		rule, err := rrule.Parse(m.RRule)
+		if err != nil {
+			return nil, err
+		}
+		starts = rule.Between(start, from, to, 0)
+	}
+
+	var out []time.Time
+	for _, t := range starts {
+		if t.Before(from) || !t.Before(to) || m.cancelled(t) {
+			continue
+		}
+		out = append(out, t)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Occurrence describes the occurrence of the meeting starting at start
+func (m *Meeting) Occurrence(start time.Time) Occurrence {
+	return Occurrence{
+		MeetingID: m.ID,
+		Title:     m.Title,
+		Start:     start,
+		End:       start.Add(time.Duration(m.DurationMinutes) * time.Minute),
+		Timezone:  m.Timezone,
+		Location:  m.Location,
+		Link:      m.Link,
+	}
+}
+
+func (m *Meeting) cancelled(t time.Time) bool {
+	for _, e := range m.Exceptions {
+		if e.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadMeetings returns the group's meetings, or only the one with meetingID if it is not 0
+func loadMeetings(groupID, meetingID int) ([]Meeting, error) {
+	rows, err := internal.DB.Query(
+		`SELECT id, group_id, title, starts_at, duration_minutes, timezone, COALESCE(rrule, ''),
+		        COALESCE(location, ''), COALESCE(link, ''), COALESCE(agenda, ''), created_by, created_at
+		   FROM meetings
+		  WHERE group_id = $1 AND ($2 = 0 OR id = $2)
+		  ORDER BY starts_at, id`,
+		groupID, meetingID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	meetings := make([]Meeting, 0)
+	index := make(map[int]int)
+	for rows.Next() {
+		var m Meeting
+		var createdBy sql.NullInt64
+		if err := rows.Scan(
+			&m.ID, &m.GroupID, &m.Title, &m.StartsAt, &m.DurationMinutes, &m.Timezone, &m.RRule,
+			&m.Location, &m.Link, &m.Agenda, &createdBy, &m.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		m.CreatedBy = nullableInt(createdBy)
+		m.Exceptions = make([]time.Time, 0)
+		index[m.ID] = len(meetings)
+		meetings = append(meetings, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	exceptions, err := internal.DB.Query(
+		`SELECT e.meeting_id, e.occurs_at
+		   FROM meeting_exceptions e
+		   JOIN meetings m ON m.id = e.meeting_id
+		  WHERE m.group_id = $1 AND ($2 = 0 OR m.id = $2)
+		  ORDER BY e.occurs_at`,
+		groupID, meetingID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer exceptions.Close()
+	for exceptions.Next() {
+		var id int
+		var at time.Time
+		if err := exceptions.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			meetings[i].Exceptions = append(meetings[i].Exceptions, at)
+		}
+	}
+	return meetings, exceptions.Err()
+}
+
+// groupOccurrences expands all meetings of the group in [from, to), sorted by start
+func groupOccurrences(groupID int, from, to time.Time) ([]Occurrence, error) {
+	meetings, err := loadMeetings(groupID, 0)
+	if err != nil {
+		return nil, err
+	}
+	occurrences := make([]Occurrence, 0)
+	for i := range meetings {
+		starts, err := meetings[i].Occurrences(from, to, 0)
+		if err != nil {
+			return nil, fmt.Errorf("meeting %d: %w", meetings[i].ID, err)
+		}
+		for _, t := range starts {
+			occurrences = append(occurrences, meetings[i].Occurrence(t))
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool { return occurrences[i].Start.Before(occurrences[j].Start) })
+	return occurrences, nil
+}
+
+// nextOccurrence returns the group's next meeting starting after now, or nil
+func nextOccurrence(groupID int, now time.Time) (*Occurrence, error) {
+	meetings, err := loadMeetings(groupID, 0)
+	if err != nil {
+		return nil, err
+	}
+	var next *Occurrence
+	for i := range meetings {
+		starts, err := meetings[i].Occurrences(now, now.Add(nextMeetingHorizon), 1)
+		if err != nil {
+			return nil, fmt.Errorf("meeting %d: %w", meetings[i].ID, err)
+		}
+		if len(starts) > 0 && (next == nil || starts[0].Before(next.Start)) {
+			o := meetings[i].Occurrence(starts[0])
+			next = &o
+		}
+	}
+	return next, nil
+}
+
+// saveExceptions replaces the cancelled occurrences of a meeting
+func saveExceptions(tx *sql.Tx, meetingID int, exceptions []time.Time) error {
+	if _, err := tx.Exec("DELETE FROM meeting_exceptions WHERE meeting_id = $1", meetingID); err != nil {
+		return err
+	}
+	for _, at := range exceptions {
+		if _, err := tx.Exec(
+			"INSERT INTO meeting_exceptions (meeting_id, occurs_at) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			meetingID, at,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// meetingID parses the {id} path value, writing a 400 response on failure
+func meetingID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid meeting ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeMeeting loads a meeting of the group and writes it with the given status
+func writeMeeting(w http.ResponseWriter, groupID, id, status int) {
+	meetings, err := loadMeetings(groupID, id)
+	if err != nil {
+		http.Error(w, "Failed to load meeting: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(meetings) == 0 {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(meetings[0])
+}
+
+// ListMeetingsHandler handles GET /group/meetings
+func ListMeetingsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	meetings, err := loadMeetings(groupID, 0)
+	if err != nil {
+		http.Error(w, "Failed to query meetings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(meetings)
+}
+
+// CreateMeetingHandler handles POST /group/meetings
+func CreateMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+		return
+	}
+
+	var req meetingReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow(
+		`INSERT INTO meetings
+		   (group_id, title, starts_at, duration_minutes, timezone, rrule, location, link, agenda, created_by)
+		 VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10)
+		 RETURNING id`,
+		groupID, req.Title, req.StartsAt, req.DurationMinutes, req.Timezone, req.RRule,
+		req.Location, req.Link, req.Agenda, principal.UserID,
+	).Scan(&id); err != nil {
+		http.Error(w, "Could not create meeting: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := saveExceptions(tx, id, req.Exceptions); err != nil {
+		http.Error(w, "Could not save exceptions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writeMeeting(w, groupID, id, http.StatusCreated)
+}
+
+// GetMeetingHandler handles GET /group/meetings/{id}
+func GetMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	id, ok := meetingID(w, r)
+	if !ok {
+		return
+	}
+	writeMeeting(w, groupID, id, http.StatusOK)
+}
+
+// UpdateMeetingHandler handles PUT /group/meetings/{id}
+func UpdateMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+		return
+	}
+
+	id, ok := meetingID(w, r)
+	if !ok {
+		return
+	}
+
+	var req meetingReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE meetings
+		    SET title = $1, starts_at = $2, duration_minutes = $3, timezone = $4, rrule = NULLIF($5, ''),
+		        location = NULLIF($6, ''), link = NULLIF($7, ''), agenda = NULLIF($8, '')
+		  WHERE id = $9 AND group_id = $10`,
+		req.Title, req.StartsAt, req.DurationMinutes, req.Timezone, req.RRule,
+		req.Location, req.Link, req.Agenda, id, groupID,
+	)
+	if err != nil {
+		http.Error(w, "Could not update meeting: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+	if err := saveExceptions(tx, id, req.Exceptions); err != nil {
+		http.Error(w, "Could not save exceptions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writeMeeting(w, groupID, id, http.StatusOK)
+}
+
+// DeleteMeetingHandler handles DELETE /group/meetings/{id}
+func DeleteMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+		return
+	}
+
+	id, ok := meetingID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := internal.DB.Exec("DELETE FROM meetings WHERE id = $1 AND group_id = $2", id, groupID)
+	if err != nil {
+		http.Error(w, "Could not delete meeting: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Meeting deleted"})
+}
+
+// OccurrencesHandler handles GET /group/meetings/occurrences
+func OccurrencesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	from := time.Now()
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "from must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	to := from.AddDate(0, 0, occurrenceDefaultDays)
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "to must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) || to.Sub(from) > occurrenceMaxRange {
+		http.Error(w, "to must be after from and at most 366 days later", http.StatusBadRequest)
+		return
+	}
+
+	occurrences, err := groupOccurrences(groupID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to expand meetings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(occurrences)
+}
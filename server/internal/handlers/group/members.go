@@ -0,0 +1,229 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+var (
+	errNotOwner     = errors.New("only the owner can transfer the group")
+	errNoSuchMember = errors.New("the new owner must be a member of the group")
+	errSelfTransfer = errors.New("you already own the group")
+)
+
+type changeRoleReq struct {
+	Role string `json:"role"`
+}
+
+type transferReq struct {
+	UserID int `json:"userId"`
+}
+
+// MemberRole is the role of a member after a change
+type MemberRole struct {
+	UserID  int       `json:"userId"`
+	GroupID int       `json:"groupId"`
+	Role    auth.Role `json:"role"`
+}
+
+// memberRole returns the role of a user in a group and locks the membership
+func memberRole(tx *sql.Tx, groupID, userID int) (auth.Role, error) {
+	var role auth.Role
+	err := tx.QueryRow(
+		`SELECT role FROM group_memberships
+		  WHERE group_id = $1 AND user_id = $2
+		    FOR UPDATE`,
+		groupID, userID,
+	).Scan(&role)
+	return role, err
+}
+
+// transferOwnership makes toUserID the owner of the group and demotes the
+// current owner fromUserID to admin
+func transferOwnership(tx *sql.Tx, groupID, fromUserID, toUserID int) error {
+	if fromUserID == toUserID {
+		return errSelfTransfer
+	}
+	// Demote first, a group can only have one owner at a time
+	result, err := tx.Exec(
+		`UPDATE group_memberships SET role = $1
+		  WHERE group_id = $2 AND user_id = $3 AND role = $4`,
+		auth.RoleAdmin, groupID, fromUserID, auth.RoleOwner,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errNotOwner
+	}
+	result, err = tx.Exec(
+		`UPDATE group_memberships SET role = $1
+		  WHERE group_id = $2 AND user_id = $3`,
+		auth.RoleOwner, groupID, toUserID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errNoSuchMember
+	}
+	return nil
+}
+
+// writeTransferError maps transferOwnership errors to responses
+func writeTransferError(w http.ResponseWriter, err error) {
+	switch err {
+	case errNotOwner:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errNoSuchMember, errSelfTransfer:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Could not transfer ownership: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ChangeRoleHandler handles PUT /group/members/{id}
+func ChangeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req changeRoleReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	role, ok := auth.ParseRole(req.Role)
+	if !ok {
+		http.Error(w, "role must be admin, member or viewer", http.StatusBadRequest)
+		return
+	}
+	if role == auth.RoleOwner {
+		http.Error(w, "Use POST /group/transfer to hand over ownership", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Roles are read from the database, the cached principal may be stale
+	actorRole, err := memberRole(tx, groupID, principal.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, auth.ErrNotMember.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "Role lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	currentRole, err := memberRole(tx, groupID, targetID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User is not a member of the group", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Role lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Members can only be managed by higher ranked roles, and only up to just below their own
+	if !actorRole.Has(auth.PermManageMembers) || !actorRole.Outranks(currentRole) || !actorRole.Outranks(role) {
+		http.Error(w, "Your role cannot assign this role to this member", http.StatusForbidden)
+		return
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE group_memberships SET role = $1 WHERE group_id = $2 AND user_id = $3",
+		role, groupID, targetID,
+	); err != nil {
+		http.Error(w, "Could not change role: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(targetID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(MemberRole{UserID: targetID, GroupID: groupID, Role: role})
+}
+
+// TransferOwnershipHandler handles POST /group/transfer
+func TransferOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermTransferOwnership) {
+		return
+	}
+	if !auth.RequireElevated(w, r) {
+		return
+	}
+
+	var req transferReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := transferOwnership(tx, groupID, userID, req.UserID); err != nil {
+		writeTransferError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(userID)
+	auth.InvalidatePrincipal(req.UserID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(MemberRole{UserID: req.UserID, GroupID: groupID, Role: auth.RoleOwner})
+}
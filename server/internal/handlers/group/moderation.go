@@ -0,0 +1,654 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+var (
+	errBanned           = errors.New("you are banned from this group")
+	errAlreadyMember    = errors.New("you are already in this group")
+	errAlreadyRequested = errors.New("you already asked to join this group")
+)
+
+type removeMemberReq struct {
+	Tasks string `json:"tasks"` // "reassign" (default) or "anonymise"
+}
+
+type banReq struct {
+	UserID int    `json:"userId"`
+	Reason string `json:"reason"`
+	Tasks  string `json:"tasks"` // Applies if the user is a member, see removeMemberReq
+}
+
+// Ban is a user who may not join the group
+type Ban struct {
+	UserID    int       `json:"userId"`
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason,omitempty"`
+	BannedBy  *int      `json:"bannedBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// JoinRequest is a pending request to join a group that requires approval
+type JoinRequest struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"userId"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// grantFunc checks inside the join transaction that an invite or invitation
+// can be used, consumes it and returns the role it gives
+type grantFunc func(tx *sql.Tx) (auth.Role, error)
+
+// joinGroup adds the user to the group, or records a join request if the
+// group requires approval. Banned users are refused. grant is nil when the
+// user joins with the group code.
+func joinGroup(groupID, userID int, grant grantFunc) (pending bool, err error) {
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// The group row lock orders joins against concurrent bans
+	var approval, archived, banned bool
+	if err := tx.QueryRow(
+		`SELECT join_approval, archived_at IS NOT NULL,
+		        EXISTS (SELECT 1 FROM group_bans WHERE group_id = $1 AND user_id = $2)
+		   FROM groups
+		  WHERE id = $1
+		    FOR UPDATE`,
+		groupID, userID,
+	).Scan(&approval, &archived, &banned); err != nil {
+		return false, err
+	}
+	if archived {
+		return false, ErrArchived
+	}
+	if banned {
+		return false, errBanned
+	}
+
+	var isMember bool
+	if err := tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM group_memberships WHERE group_id = $1 AND user_id = $2)",
+		groupID, userID,
+	).Scan(&isMember); err != nil {
+		return false, err
+	}
+	if isMember {
+		return false, errAlreadyMember
+	}
+
+	role := auth.RoleMember
+	if grant != nil {
+		// Invites are handed out by admins, so they skip join approval
+		if role, err = grant(tx); err != nil {
+			return false, err
+		}
+		approval = false
+	}
+
+	if approval {
+		result, err := tx.Exec(
+			`INSERT INTO join_requests (group_id, user_id)
+			 VALUES ($1, $2)
+			 ON CONFLICT DO NOTHING`,
+			groupID, userID,
+		)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return false, errAlreadyRequested
+		}
+		return true, tx.Commit()
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO group_memberships (group_id, user_id, role)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		groupID, userID, role,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, errAlreadyMember
+	}
+	// Pending requests and invitations are settled by joining
+	if _, err := tx.Exec(
+		"DELETE FROM join_requests WHERE group_id = $1 AND user_id = $2",
+		groupID, userID,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM invitations WHERE group_id = $1 AND user_id = $2",
+		groupID, userID,
+	); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	auth.InvalidatePrincipal(userID)
+	return false, nil
+}
+
+// removeMember deletes a membership. The tasks the member created in the group
+// are handed to the group owner, or kept without an author for "anonymise".
+// The member is unassigned from open tasks, completed ones keep the credit.
+func removeMember(tx *sql.Tx, groupID, userID int, tasks string) error {
+	if _, err := tx.Exec(
+		"DELETE FROM group_memberships WHERE group_id = $1 AND user_id = $2",
+		groupID, userID,
+	); err != nil {
+		return err
+	}
+	if err := unassignOpenTasks(tx, groupID, userID); err != nil {
+		return err
+	}
+
+	if tasks == "anonymise" {
+		_, err := tx.Exec(
+			"UPDATE tasks SET creator_user_id = NULL WHERE group_id = $1 AND creator_user_id = $2",
+			groupID, userID,
+		)
+		return err
+	}
+	_, err := tx.Exec(
+		`UPDATE tasks
+		    SET creator_user_id = (SELECT user_id FROM group_memberships WHERE group_id = $1 AND role = $3)
+		  WHERE group_id = $1 AND creator_user_id = $2`,
+		groupID, userID, auth.RoleOwner,
+	)
+	return err
+}
+
+// unassignOpenTasks takes a former member off the group's open tasks.
+// Completed tasks keep their assignees, who were credited for them.
+func unassignOpenTasks(tx *sql.Tx, groupID, userID int) error {
+	_, err := tx.Exec(
+		`DELETE FROM task_assignees a
+		  USING tasks t
+		  WHERE t.id = a.task_id AND t.group_id = $1 AND a.user_id = $2 AND NOT t.completed`,
+		groupID, userID,
+	)
+	return err
+}
+
+// validTaskHandling checks the tasks option of removals, defaulting to "reassign"
+func validTaskHandling(tasks *string) bool {
+	if *tasks == "" {
+		*tasks = "reassign"
+	}
+	return *tasks == "reassign" || *tasks == "anonymise"
+}
+
+// requireOutranks checks inside tx that the acting user may manage the target,
+// whose role is "" if not a member. On failure the response has been written.
+func requireOutranks(w http.ResponseWriter, tx *sql.Tx, groupID, actorID int, targetRole auth.Role) bool {
+	actorRole, err := memberRole(tx, groupID, actorID)
+	if err == sql.ErrNoRows {
+		http.Error(w, auth.ErrNotMember.Error(), http.StatusForbidden)
+		return false
+	} else if err != nil {
+		http.Error(w, "Role lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !actorRole.Has(auth.PermManageMembers) || !actorRole.Outranks(targetRole) {
+		http.Error(w, "Your role cannot manage this member", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// RemoveMemberHandler handles DELETE /group/members/{id}
+func RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req removeMemberReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !validTaskHandling(&req.Tasks) {
+		http.Error(w, `tasks must be "reassign" or "anonymise"`, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	targetRole, err := memberRole(tx, groupID, targetID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User is not a member of the group", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Role lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !requireOutranks(w, tx, groupID, principal.UserID, targetRole) {
+		return
+	}
+
+	if err := removeMember(tx, groupID, targetID, req.Tasks); err != nil {
+		http.Error(w, "Could not remove member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(targetID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Member removed"})
+}
+
+// ListBansHandler handles GET /group/bans
+func ListBansHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT b.user_id, u.username, COALESCE(b.reason, ''), b.banned_by, b.created_at
+		   FROM group_bans b
+		   JOIN users u ON u.id = b.user_id
+		  WHERE b.group_id = $1
+		  ORDER BY b.created_at DESC`,
+		groupID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to query bans: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	bans := make([]Ban, 0)
+	for rows.Next() {
+		var b Ban
+		var bannedBy sql.NullInt64
+		if err := rows.Scan(&b.UserID, &b.Username, &b.Reason, &bannedBy, &b.CreatedAt); err != nil {
+			http.Error(w, "Failed to scan ban: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if bannedBy.Valid {
+			id := int(bannedBy.Int64)
+			b.BannedBy = &id
+		}
+		bans = append(bans, b)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Error iterating over bans: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(bans)
+}
+
+// BanHandler handles POST /group/bans
+func BanHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	var req banReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID == 0 {
+		http.Error(w, "userId is required", http.StatusBadRequest)
+		return
+	}
+	if !validTaskHandling(&req.Tasks) {
+		http.Error(w, `tasks must be "reassign" or "anonymise"`, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Joins lock the group row as well, see joinGroup
+	if _, err := tx.Exec("SELECT 1 FROM groups WHERE id = $1 FOR UPDATE", groupID); err != nil {
+		http.Error(w, "Failed to lock group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	targetRole, err := memberRole(tx, groupID, req.UserID)
+	isMember := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Role lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !requireOutranks(w, tx, groupID, principal.UserID, targetRole) {
+		return
+	}
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", req.UserID).Scan(&exists); err != nil {
+		http.Error(w, "User lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if isMember {
+		if err := removeMember(tx, groupID, req.UserID, req.Tasks); err != nil {
+			http.Error(w, "Could not remove member: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM join_requests WHERE group_id = $1 AND user_id = $2",
+		groupID, req.UserID,
+	); err != nil {
+		http.Error(w, "Could not drop join request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM invitations WHERE group_id = $1 AND user_id = $2",
+		groupID, req.UserID,
+	); err != nil {
+		http.Error(w, "Could not drop invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO group_bans (group_id, user_id, banned_by, reason)
+		 VALUES ($1, $2, $3, NULLIF($4, ''))
+		 ON CONFLICT (group_id, user_id) DO UPDATE SET reason = EXCLUDED.reason, banned_by = EXCLUDED.banned_by`,
+		groupID, req.UserID, principal.UserID, req.Reason,
+	); err != nil {
+		http.Error(w, "Could not ban user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(req.UserID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp{Message: "User banned"})
+}
+
+// UnbanHandler handles DELETE /group/bans/{id}
+func UnbanHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := internal.DB.Exec(
+		"DELETE FROM group_bans WHERE group_id = $1 AND user_id = $2",
+		groupID, userID,
+	)
+	if err != nil {
+		http.Error(w, "Could not lift ban: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "User is not banned", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Ban lifted"})
+}
+
+// ListJoinRequestsHandler handles GET /group/requests
+func ListJoinRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	rows, err := internal.DB.Query(
+		`SELECT j.id, j.user_id, u.username, j.created_at
+		   FROM join_requests j
+		   JOIN users u ON u.id = j.user_id
+		  WHERE j.group_id = $1
+		  ORDER BY j.created_at`,
+		groupID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to query join requests: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	requests := make([]JoinRequest, 0)
+	for rows.Next() {
+		var j JoinRequest
+		if err := rows.Scan(&j.ID, &j.UserID, &j.Username, &j.CreatedAt); err != nil {
+			http.Error(w, "Failed to scan join request: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		requests = append(requests, j)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Error iterating over join requests: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(requests)
+}
+
+// AcceptJoinRequestHandler handles POST /group/requests/{id}/accept
+func AcceptJoinRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	requestID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	archived, err := Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Failed to lock group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	var userID int
+	err = tx.QueryRow(
+		"DELETE FROM join_requests WHERE id = $1 AND group_id = $2 RETURNING user_id",
+		requestID, groupID,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Join request not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not accept join request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO group_memberships (group_id, user_id)
+		 VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		groupID, userID,
+	); err != nil {
+		http.Error(w, "Could not add member: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM invitations WHERE group_id = $1 AND user_id = $2",
+		groupID, userID,
+	); err != nil {
+		http.Error(w, "Could not drop invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(userID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Join request accepted"})
+}
+
+// RejectJoinRequestHandler handles DELETE /group/requests/{id}
+func RejectJoinRequestHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermManageMembers) {
+		return
+	}
+
+	requestID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid request ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := internal.DB.Exec(
+		"DELETE FROM join_requests WHERE id = $1 AND group_id = $2",
+		requestID, groupID,
+	)
+	if err != nil {
+		http.Error(w, "Could not reject join request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Join request not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Join request rejected"})
+}
@@ -0,0 +1,609 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+const pollMaxSlots = 50
+
+var errPollClosed = errors.New("poll is closed")
+
+type pollReq struct {
+	Title           string      `json:"title"`
+	DurationMinutes int         `json:"durationMinutes"`
+	Timezone        string      `json:"timezone"`
+	Location        string      `json:"location"`
+	Link            string      `json:"link"`
+	Slots           []time.Time `json:"slots"` // Candidate start times
+}
+
+type pollVote struct {
+	SlotID int    `json:"slotId"`
+	Vote   string `json:"vote"` // available, if_needed or unavailable
+}
+
+type votePollReq struct {
+	Votes []pollVote `json:"votes"`
+}
+
+type closePollReq struct {
+	SlotID int `json:"slotId"` // Optional, overrides the winner of the vote
+}
+
+// PollSlot is a candidate time of a poll with its live tally
+type PollSlot struct {
+	ID          int       `json:"id"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Available   int       `json:"available"`
+	IfNeeded    int       `json:"ifNeeded"`
+	Unavailable int       `json:"unavailable"`
+	MyVote      string    `json:"myVote,omitempty"`
+}
+
+// PollVote is one member's vote for a slot
+type PollVote struct {
+	SlotID   int    `json:"slotId"`
+	UserID   int    `json:"userId"`
+	Username string `json:"username"`
+	Vote     string `json:"vote"`
+}
+
+// Poll lets members vote on candidate times for a meeting
+type Poll struct {
+	ID              int        `json:"id"`
+	Title           string     `json:"title"`
+	DurationMinutes int        `json:"durationMinutes"`
+	Timezone        string     `json:"timezone"`
+	Location        string     `json:"location,omitempty"`
+	Link            string     `json:"link,omitempty"`
+	CreatedBy       *int       `json:"createdBy,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	ClosedAt        *time.Time `json:"closedAt,omitempty"`
+	MeetingID       *int       `json:"meetingId,omitempty"` // The meeting scheduled when the poll closed
+	Slots           []PollSlot `json:"slots"`
+	Votes           []PollVote `json:"votes,omitempty"` // Only in GET /group/polls/{id}
+}
+
+// validate normalises the request like a one-off meeting. It returns a
+// message for invalid input.
+func (req *pollReq) validate() string {
+	if len(req.Slots) == 0 || len(req.Slots) > pollMaxSlots {
+		return "slots must have between 1 and 50 start times"
+	}
+	now := time.Now()
+	seen := make(map[int64]bool)
+	for _, t := range req.Slots {
+		if !t.After(now) {
+			return "slots must be in the future"
+		}
+		if seen[t.Unix()] {
+			return "slots must not repeat"
+		}
+		seen[t.Unix()] = true
+	}
+
+	m := meetingReq{
+		Title:           req.Title,
+		StartsAt:        req.Slots[0],
+		DurationMinutes: req.DurationMinutes,
+		Timezone:        req.Timezone,
+		Location:        req.Location,
+		Link:            req.Link,
+	}
+	if msg := m.validate(); msg != "" {
+		return msg
+	}
+	req.Title, req.DurationMinutes, req.Timezone = m.Title, m.DurationMinutes, m.Timezone
+	return ""
+}
+
+// winner returns the slot with the most available votes, counting if_needed
+// votes and then the earlier start to break ties. It returns nil if nobody
+// is available for any slot.
+func (p *Poll) winner() *PollSlot {
+	slots := make([]*PollSlot, len(p.Slots))
+	for i := range p.Slots {
+		slots[i] = &p.Slots[i]
+	}
+	sort.SliceStable(slots, func(i, j int) bool {
+		a, b := slots[i], slots[j]
+		if a.Available != b.Available {
+			return a.Available > b.Available
+		}
+		if a.IfNeeded != b.IfNeeded {
+			return a.IfNeeded > b.IfNeeded
+		}
+		return a.Start.Before(b.Start)
+	})
+	if len(slots) == 0 || slots[0].Available+slots[0].IfNeeded == 0 {
+		return nil
+	}
+	return slots[0]
+}
+
+// querier runs queries on the database or inside a transaction
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// loadPolls returns the group's polls with their tallies, or only the one with
+// pollID if it is not 0. myVote is filled in for userID.
+func loadPolls(db querier, groupID, pollID, userID int) ([]Poll, error) {
+	rows, err := db.Query(
+		`SELECT id, title, duration_minutes, timezone, COALESCE(location, ''), COALESCE(link, ''),
+		        created_by, created_at, closed_at, meeting_id
+		   FROM meeting_polls
+		  WHERE group_id = $1 AND ($2 = 0 OR id = $2)
+		  ORDER BY created_at DESC, id DESC`,
+		groupID, pollID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	polls := make([]Poll, 0)
+	index := make(map[int]int)
+	for rows.Next() {
+		var p Poll
+		var createdBy, meetingID sql.NullInt64
+		var closedAt sql.NullTime
+		if err := rows.Scan(
+			&p.ID, &p.Title, &p.DurationMinutes, &p.Timezone, &p.Location, &p.Link,
+			&createdBy, &p.CreatedAt, &closedAt, &meetingID,
+		); err != nil {
+			return nil, err
+		}
+		p.CreatedBy = nullableInt(createdBy)
+		p.MeetingID = nullableInt(meetingID)
+		if closedAt.Valid {
+			p.ClosedAt = &closedAt.Time
+		}
+		p.Slots = make([]PollSlot, 0)
+		index[p.ID] = len(polls)
+		polls = append(polls, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slots, err := db.Query(
+		`SELECT s.poll_id, s.id, s.starts_at,
+		        COUNT(v.user_id) FILTER (WHERE v.vote = 'available'),
+		        COUNT(v.user_id) FILTER (WHERE v.vote = 'if_needed'),
+		        COUNT(v.user_id) FILTER (WHERE v.vote = 'unavailable'),
+		        COALESCE(MAX(v.vote) FILTER (WHERE v.user_id = $3), '')
+		   FROM meeting_poll_slots s
+		   JOIN meeting_polls p ON p.id = s.poll_id
+		   LEFT JOIN meeting_poll_votes v ON v.slot_id = s.id
+		  WHERE p.group_id = $1 AND ($2 = 0 OR p.id = $2)
+		  GROUP BY s.id
+		  ORDER BY s.starts_at`,
+		groupID, pollID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer slots.Close()
+	for slots.Next() {
+		var id int
+		var s PollSlot
+		if err := slots.Scan(&id, &s.ID, &s.Start, &s.Available, &s.IfNeeded, &s.Unavailable, &s.MyVote); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			p := &polls[i]
+			if loc, err := time.LoadLocation(p.Timezone); err == nil {
+				s.Start = s.Start.In(loc)
+			}
+			s.End = s.Start.Add(time.Duration(p.DurationMinutes) * time.Minute)
+			p.Slots = append(p.Slots, s)
+		}
+	}
+	return polls, slots.Err()
+}
+
+// loadPollVotes returns who voted what in a poll
+func loadPollVotes(pollID int) ([]PollVote, error) {
+	rows, err := internal.DB.Query(
+		`SELECT v.slot_id, v.user_id, u.username, v.vote
+		   FROM meeting_poll_votes v
+		   JOIN meeting_poll_slots s ON s.id = v.slot_id
+		   JOIN users u ON u.id = v.user_id
+		  WHERE s.poll_id = $1
+		  ORDER BY s.starts_at, u.username`,
+		pollID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := make([]PollVote, 0)
+	for rows.Next() {
+		var v PollVote
+		if err := rows.Scan(&v.SlotID, &v.UserID, &v.Username, &v.Vote); err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
+// lockOpenPoll locks a poll of the group, failing with errPollClosed once it is closed
+func lockOpenPoll(tx *sql.Tx, groupID, pollID int) error {
+	var closed bool
+	err := tx.QueryRow(
+		"SELECT closed_at IS NOT NULL FROM meeting_polls WHERE id = $1 AND group_id = $2 FOR UPDATE",
+		pollID, groupID,
+	).Scan(&closed)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return errPollClosed
+	}
+	return nil
+}
+
+// pollID parses the {id} path value, writing a 400 response on failure
+func pollID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid poll ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// writePoll loads a poll of the group with its votes and writes it with the given status
+func writePoll(w http.ResponseWriter, groupID, id, userID, status int) {
+	polls, err := loadPolls(internal.DB, groupID, id, userID)
+	if err != nil {
+		http.Error(w, "Failed to load poll: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(polls) == 0 {
+		http.Error(w, "Poll not found", http.StatusNotFound)
+		return
+	}
+	if polls[0].Votes, err = loadPollVotes(id); err != nil {
+		http.Error(w, "Failed to load votes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(polls[0])
+}
+
+// writePollTxError maps the errors of lockOpenPoll to a response
+func writePollTxError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Poll not found", http.StatusNotFound)
+	case errors.Is(err, errPollClosed):
+		http.Error(w, "The poll is closed", http.StatusConflict)
+	default:
+		http.Error(w, "Failed to load poll: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ListPollsHandler handles GET /group/polls
+func ListPollsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	polls, err := loadPolls(internal.DB, groupID, 0, principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to query polls: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(polls)
+}
+
+// CreatePollHandler handles POST /group/polls
+func CreatePollHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+		return
+	}
+
+	var req pollReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow(
+		`INSERT INTO meeting_polls (group_id, title, duration_minutes, timezone, location, link, created_by)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+		 RETURNING id`,
+		groupID, req.Title, req.DurationMinutes, req.Timezone, req.Location, req.Link, principal.UserID,
+	).Scan(&id); err != nil {
+		http.Error(w, "Could not create poll: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, t := range req.Slots {
+		if _, err := tx.Exec("INSERT INTO meeting_poll_slots (poll_id, starts_at) VALUES ($1, $2)", id, t); err != nil {
+			http.Error(w, "Could not save slots: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writePoll(w, groupID, id, principal.UserID, http.StatusCreated)
+}
+
+// GetPollHandler handles GET /group/polls/{id}
+func GetPollHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	id, ok := pollID(w, r)
+	if !ok {
+		return
+	}
+	writePoll(w, groupID, id, principal.UserID, http.StatusOK)
+}
+
+// DeletePollHandler handles DELETE /group/polls/{id}
+func DeletePollHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+		return
+	}
+
+	id, ok := pollID(w, r)
+	if !ok {
+		return
+	}
+
+	// A meeting scheduled by the poll stays
+	result, err := internal.DB.Exec("DELETE FROM meeting_polls WHERE id = $1 AND group_id = $2", id, groupID)
+	if err != nil {
+		http.Error(w, "Could not delete poll: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Poll not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp{Message: "Poll deleted"})
+}
+
+// VotePollHandler handles PUT /group/polls/{id}/votes
+func VotePollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	id, ok := pollID(w, r)
+	if !ok {
+		return
+	}
+
+	var req votePollReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Votes) == 0 {
+		http.Error(w, "votes is required", http.StatusBadRequest)
+		return
+	}
+	for _, v := range req.Votes {
+		if v.Vote != "available" && v.Vote != "if_needed" && v.Vote != "unavailable" {
+			http.Error(w, `vote must be "available", "if_needed" or "unavailable"`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockOpenPoll(tx, groupID, id); err != nil {
+		writePollTxError(w, err)
+		return
+	}
+	for _, v := range req.Votes {
+		result, err := tx.Exec(
+			`INSERT INTO meeting_poll_votes (slot_id, user_id, vote)
+			 SELECT id, $3, $4 FROM meeting_poll_slots WHERE id = $1 AND poll_id = $2
+			 ON CONFLICT (slot_id, user_id) DO UPDATE SET vote = EXCLUDED.vote, updated_at = NOW()`,
+			v.SlotID, id, principal.UserID, v.Vote,
+		)
+		if err != nil {
+			http.Error(w, "Could not save vote: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Slot "+strconv.Itoa(v.SlotID)+" is not part of the poll", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writePoll(w, groupID, id, principal.UserID, http.StatusOK)
+}
+
+// ClosePollHandler handles POST /group/polls/{id}/close
+func ClosePollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermScheduleMeetings) {
+		return
+	}
+
+	id, ok := pollID(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional
+	var req closePollReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock first so that no vote changes the tally while it is read
+	if err := lockOpenPoll(tx, groupID, id); err != nil {
+		writePollTxError(w, err)
+		return
+	}
+	polls, err := loadPolls(tx, groupID, id, principal.UserID)
+	if err != nil || len(polls) == 0 {
+		http.Error(w, "Failed to load poll", http.StatusInternalServerError)
+		return
+	}
+	p := &polls[0]
+
+	var slot *PollSlot
+	if req.SlotID != 0 {
+		for i := range p.Slots {
+			if p.Slots[i].ID == req.SlotID {
+				slot = &p.Slots[i]
+			}
+		}
+		if slot == nil {
+			http.Error(w, "Slot "+strconv.Itoa(req.SlotID)+" is not part of the poll", http.StatusBadRequest)
+			return
+		}
+	} else if slot = p.winner(); slot == nil {
+		http.Error(w, "Nobody is available for any slot, choose one with slotId", http.StatusConflict)
+		return
+	}
+
+	var scheduled int
+	if err := tx.QueryRow(
+		`INSERT INTO meetings (group_id, title, starts_at, duration_minutes, timezone, location, link, created_by)
+		 VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8)
+		 RETURNING id`,
+		groupID, p.Title, slot.Start, p.DurationMinutes, p.Timezone, p.Location, p.Link, principal.UserID,
+	).Scan(&scheduled); err != nil {
+		http.Error(w, "Could not schedule meeting: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(
+		"UPDATE meeting_polls SET closed_at = NOW(), meeting_id = $1 WHERE id = $2",
+		scheduled, id,
+	); err != nil {
+		http.Error(w, "Could not close poll: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	writeMeeting(w, groupID, scheduled, http.StatusCreated)
+}
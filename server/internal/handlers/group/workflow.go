@@ -0,0 +1,369 @@
+package group
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+const (
+	workflowMaxStates    = 20
+	workflowMaxName      = 50
+	workflowDefaultColor = "#9ca3af"
+)
+
+var workflowColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type workflowStateReq struct {
+	ID       int    `json:"id"` // 0 for a new state
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	WIPLimit *int   `json:"wipLimit"`
+	Done     bool   `json:"done"`
+}
+
+type workflowTransitionReq struct {
+	From string `json:"from"` // State names, new states have no ID yet
+	To   string `json:"to"`
+}
+
+type workflowReq struct {
+	States      []workflowStateReq       `json:"states"` // In board order
+	Transitions *[]workflowTransitionReq `json:"transitions"`
+}
+
+// WorkflowState is a column of the group's board
+type WorkflowState struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	Position int    `json:"position"`
+	WIPLimit *int   `json:"wipLimit"`
+	Done     bool   `json:"done"` // Moving a task here completes it
+	Tasks    int    `json:"tasks"`
+}
+
+// WorkflowTransition allows tasks to move from one state to another
+type WorkflowTransition struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// Workflow is the group's states and the moves allowed between them
+type Workflow struct {
+	States      []WorkflowState      `json:"states"`
+	Transitions []WorkflowTransition `json:"transitions"`
+}
+
+// validate normalises the request. It returns a message for invalid input.
+func (req *workflowReq) validate() string {
+	if len(req.States) == 0 || len(req.States) > workflowMaxStates {
+		return "states must have between 1 and 20 entries"
+	}
+	names := make(map[string]bool)
+	ids := make(map[int]bool)
+	for i := range req.States {
+		s := &req.States[i]
+		s.Name = strings.TrimSpace(s.Name)
+		if s.Name == "" || len(s.Name) > workflowMaxName {
+			return "state names are required and must be at most 50 characters"
+		}
+		if names[strings.ToLower(s.Name)] {
+			return fmt.Sprintf("state %q appears twice", s.Name)
+		}
+		names[strings.ToLower(s.Name)] = true
+		if s.ID != 0 {
+			if ids[s.ID] {
+				return fmt.Sprintf("state ID %d appears twice", s.ID)
+			}
+			ids[s.ID] = true
+		}
+		if s.Color == "" {
+			s.Color = workflowDefaultColor
+		}
+		if !workflowColor.MatchString(s.Color) {
+			return "color must be a hex colour like #3b82f6"
+		}
+		if s.WIPLimit != nil && *s.WIPLimit < 1 {
+			return "wipLimit must be at least 1"
+		}
+	}
+
+	// Without transitions every state can be reached from every other
+	if req.Transitions == nil {
+		t := make([]workflowTransitionReq, 0, len(req.States)*(len(req.States)-1))
+		for _, a := range req.States {
+			for _, b := range req.States {
+				if a.Name != b.Name {
+					t = append(t, workflowTransitionReq{From: a.Name, To: b.Name})
+				}
+			}
+		}
+		req.Transitions = &t
+	}
+	for _, t := range *req.Transitions {
+		if !names[strings.ToLower(t.From)] || !names[strings.ToLower(t.To)] {
+			return fmt.Sprintf("transition %q to %q refers to an unknown state", t.From, t.To)
+		}
+		if strings.EqualFold(t.From, t.To) {
+			return fmt.Sprintf("transition from %q to itself", t.From)
+		}
+	}
+	return ""
+}
+
+// loadWorkflow returns the states of the group in board order and its transitions
+func loadWorkflow(groupID int) (*Workflow, error) {
+	wf := &Workflow{States: make([]WorkflowState, 0), Transitions: make([]WorkflowTransition, 0)}
+
+	rows, err := internal.DB.Query(
+		`SELECT w.id, w.name, w.color, w.position, w.wip_limit, w.is_done,
+		        (SELECT COUNT(*) FROM tasks t WHERE t.state_id = w.id)
+		   FROM workflow_states w
+		  WHERE w.group_id = $1
+		  ORDER BY w.position, w.id`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s WorkflowState
+		var limit sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.Name, &s.Color, &s.Position, &limit, &s.Done, &s.Tasks); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.WIPLimit = nullableInt(limit)
+		wf.States = append(wf.States, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = internal.DB.Query(
+		`SELECT t.from_state_id, t.to_state_id
+		   FROM workflow_transitions t
+		   JOIN workflow_states w ON w.id = t.from_state_id
+		  WHERE w.group_id = $1
+		  ORDER BY t.from_state_id, t.to_state_id`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t WorkflowTransition
+		if err := rows.Scan(&t.From, &t.To); err != nil {
+			return nil, err
+		}
+		wf.Transitions = append(wf.Transitions, t)
+	}
+	return wf, rows.Err()
+}
+
+// GetWorkflowHandler handles GET /group/workflow
+func GetWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	wf, err := loadWorkflow(groupID)
+	if err != nil {
+		http.Error(w, "Failed to load workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(wf)
+}
+
+// UpdateWorkflowHandler handles PUT /group/workflow
+func UpdateWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermEditGroup) {
+		return
+	}
+
+	var req workflowReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the group keeps task moves and creation out until the new workflow is in place
+	archived, err := Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	existing := make(map[int]bool)
+	wasDone := make(map[int]bool)
+	rows, err := tx.Query("SELECT id, is_done FROM workflow_states WHERE group_id = $1 FOR UPDATE", groupID)
+	if err != nil {
+		http.Error(w, "Failed to load workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var id int
+		var done bool
+		if err := rows.Scan(&id, &done); err != nil {
+			rows.Close()
+			http.Error(w, "Failed to load workflow: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		existing[id] = true
+		wasDone[id] = done
+	}
+	rows.Close()
+
+	stateIDs := make(map[string]int)
+	for i, s := range req.States {
+		if s.ID != 0 {
+			if !existing[s.ID] {
+				http.Error(w, fmt.Sprintf("State %d is not part of the workflow", s.ID), http.StatusBadRequest)
+				return
+			}
+			delete(existing, s.ID)
+			// The tasks of a state are completed exactly when it is done
+			if s.Done != wasDone[s.ID] {
+				var tasks int
+				if err := tx.QueryRow("SELECT COUNT(*) FROM tasks WHERE state_id = $1", s.ID).Scan(&tasks); err != nil {
+					http.Error(w, "Failed to load state: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if tasks > 0 {
+					http.Error(w,
+						fmt.Sprintf("State %q still has %d tasks, move them first to change whether it is done", s.Name, tasks),
+						http.StatusConflict,
+					)
+					return
+				}
+			}
+			if _, err := tx.Exec(
+				`UPDATE workflow_states
+				    SET name = $1, color = $2, position = $3, wip_limit = $4, is_done = $5
+				  WHERE id = $6`,
+				s.Name, s.Color, i+1, s.WIPLimit, s.Done, s.ID,
+			); err != nil {
+				http.Error(w, "Could not update state: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else if err := tx.QueryRow(
+			`INSERT INTO workflow_states (group_id, name, color, position, wip_limit, is_done)
+			 VALUES ($1, $2, $3, $4, $5, $6)
+			 RETURNING id`,
+			groupID, s.Name, s.Color, i+1, s.WIPLimit, s.Done,
+		).Scan(&s.ID); err != nil {
+			http.Error(w, "Could not create state: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stateIDs[strings.ToLower(s.Name)] = s.ID
+	}
+
+	// States left out are removed, but only once their tasks have moved on
+	for id := range existing {
+		var name string
+		var tasks int
+		if err := tx.QueryRow(
+			"SELECT name, (SELECT COUNT(*) FROM tasks WHERE state_id = $1) FROM workflow_states WHERE id = $1",
+			id,
+		).Scan(&name, &tasks); err != nil {
+			http.Error(w, "Failed to load state: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tasks > 0 {
+			http.Error(w, fmt.Sprintf("State %q still has %d tasks, move them first", name, tasks), http.StatusConflict)
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM workflow_states WHERE id = $1", id); err != nil {
+			http.Error(w, "Could not delete state: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM workflow_transitions t
+		  USING workflow_states w
+		  WHERE w.id = t.from_state_id AND w.group_id = $1`,
+		groupID,
+	); err != nil {
+		http.Error(w, "Could not replace transitions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, t := range *req.Transitions {
+		if _, err := tx.Exec(
+			"INSERT INTO workflow_transitions (from_state_id, to_state_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			stateIDs[strings.ToLower(t.From)], stateIDs[strings.ToLower(t.To)],
+		); err != nil {
+			http.Error(w, "Could not save transition: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Steps are the positions of the states, which may have been reordered
+	if _, err := tx.Exec(
+		`UPDATE tasks t
+		    SET step = w.position
+		   FROM workflow_states w
+		  WHERE w.id = t.state_id AND t.group_id = $1 AND t.step <> w.position`,
+		groupID,
+	); err != nil {
+		http.Error(w, "Could not update task steps: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	wf, err := loadWorkflow(groupID)
+	if err != nil {
+		http.Error(w, "Failed to load workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(wf)
+}
@@ -0,0 +1,94 @@
+package scoreboard
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+type Member struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Points   int    `json:"points"`
+	Member   bool   `json:"member"` // false for former members with points
+}
+
+type MemberScores struct {
+	GroupID     int      `json:"group_id"`
+	PointsScore int      `json:"points_score"`
+	Unassigned  int      `json:"unassigned"` // Points of tasks completed without assignees, and other group points
+	Members     []Member `json:"members"`
+}
+
+// MembersHandler handles GET /scoreboard/members
+func MembersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	groupID, err := principal.Group()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	scores := MemberScores{GroupID: groupID, Members: make([]Member, 0)}
+	if err := internal.DB.QueryRow(
+		"SELECT points_score FROM groups WHERE id = $1", groupID,
+	).Scan(&scores.PointsScore); err != nil {
+		http.Error(w, "failed to query group: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Current members, and former members who still have points in the group
+	rows, err := internal.DB.Query(`
+		WITH points AS (
+			SELECT user_id, SUM(points) AS points
+			FROM task_points
+			WHERE group_id = $1
+			GROUP BY user_id
+		)
+		SELECT u.id, u.username, COALESCE(p.points, 0), m.user_id IS NOT NULL
+		FROM users u
+		LEFT JOIN group_memberships m ON m.group_id = $1 AND m.user_id = u.id
+		LEFT JOIN points p ON p.user_id = u.id
+		WHERE m.user_id IS NOT NULL OR COALESCE(p.points, 0) <> 0
+		ORDER BY 3 DESC, u.username ASC
+	`, groupID)
+	if err != nil {
+		http.Error(w, "failed to query members: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attributed := 0
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Points, &m.Member); err != nil {
+			http.Error(w, "failed to scan member: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		attributed += m.Points
+		scores.Members = append(scores.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "rows iteration error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	scores.Unassigned = scores.PointsScore - attributed
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(scores); err != nil {
+		http.Error(w, "failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
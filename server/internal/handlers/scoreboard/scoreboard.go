@@ -11,6 +11,7 @@ type Group struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
 	PointsScore int    `json:"points_score"`
+	Archived    bool   `json:"archived"`
 }
 
 // ScoreboardHandler handles GET /scoreboard
@@ -22,7 +23,7 @@ func ScoreboardHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Query groups sorted by points_score
 	rows, err := internal.DB.Query(`
-		SELECT id, name, points_score
+		SELECT id, name, points_score, archived_at IS NOT NULL
 		FROM groups
 		ORDER BY points_score DESC, id ASC
 	`)
@@ -35,7 +36,7 @@ func ScoreboardHandler(w http.ResponseWriter, r *http.Request) {
 	var groups []Group
 	for rows.Next() {
 		var g Group
-		if err := rows.Scan(&g.ID, &g.Name, &g.PointsScore); err != nil {
+		if err := rows.Scan(&g.ID, &g.Name, &g.PointsScore, &g.Archived); err != nil {
 			http.Error(w, "failed to scan group: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
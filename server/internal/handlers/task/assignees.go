@@ -0,0 +1,262 @@
+package task
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"execute/internal"
+	"execute/internal/dataflow"
+	"execute/internal/handlers/auth"
+	"execute/internal/handlers/group"
+)
+
+// Assignee is a member who works on a task
+type Assignee struct {
+	UserID   int    `json:"userId"`
+	Username string `json:"username"`
+}
+
+type assigneeReq struct {
+	TaskID int `json:"taskId"`
+	UserID int `json:"userId"` // Defaults to the caller
+}
+
+// loadAssignees returns the assignees of the group's tasks by task ID
+func loadAssignees(groupID int) (map[int][]Assignee, error) {
+	rows, err := internal.DB.Query(
+		`SELECT a.task_id, a.user_id, u.username
+		   FROM task_assignees a
+		   JOIN tasks t ON t.id = a.task_id
+		   JOIN users u ON u.id = a.user_id
+		  WHERE t.group_id = $1
+		  ORDER BY u.username`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignees := make(map[int][]Assignee)
+	for rows.Next() {
+		var taskID int
+		var a Assignee
+		if err := rows.Scan(&taskID, &a.UserID, &a.Username); err != nil {
+			return nil, err
+		}
+		assignees[taskID] = append(assignees[taskID], a)
+	}
+	return assignees, rows.Err()
+}
+
+// creditAssignees splits the points of a completed task evenly between its
+// assignees. The remainder goes to the assignees with the lowest IDs, one
+// point each, so the shares add up to the points the group was credited.
+func creditAssignees(tx *sql.Tx, groupID, taskID, points int) error {
+	rows, err := tx.Query("SELECT user_id FROM task_assignees WHERE task_id = $1 ORDER BY user_id", taskID)
+	if err != nil {
+		return err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(userIDs) == 0 {
+		return err
+	}
+
+	share, remainder := points/len(userIDs), points%len(userIDs)
+	for i, id := range userIDs {
+		p := share
+		if i < remainder {
+			p++
+		}
+		if p == 0 {
+			continue
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO task_points (group_id, task_id, user_id, points) VALUES ($1, $2, $3, $4)",
+			groupID, taskID, id, p,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// debitAssignees reverses what the task's assignees were credited, even if
+// the assignees changed since the task was completed
+func debitAssignees(tx *sql.Tx, taskID int) error {
+	_, err := tx.Exec(
+		`INSERT INTO task_points (group_id, task_id, user_id, points)
+		 SELECT group_id, task_id, user_id, -SUM(points)
+		   FROM task_points
+		  WHERE task_id = $1
+		  GROUP BY group_id, task_id, user_id
+		 HAVING SUM(points) <> 0`,
+		taskID,
+	)
+	return err
+}
+
+// changeAssignee adds or removes an assignee. Members may assign themselves
+// to any task, assigning others takes the creator or a group admin.
+func changeAssignee(w http.ResponseWriter, r *http.Request, assign bool) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	var req assigneeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID == 0 {
+		req.UserID = userID
+	}
+
+	groupID, err := taskGroup(req.TaskID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Task lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !principal.IsMember(groupID) {
+		http.Error(w, "Forbidden: You are not in the same group as the task", http.StatusForbidden)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermWriteTasks) {
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	archived, err := group.Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, group.ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	// Lock the task so that it is not completed while the assignees change
+	var creatorID int
+	var completed bool
+	if err := tx.QueryRow(
+		"SELECT COALESCE(creator_user_id, 0), completed FROM tasks WHERE id = $1 FOR UPDATE",
+		req.TaskID,
+	).Scan(&creatorID, &completed); err != nil {
+		http.Error(w, "Task lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.UserID != userID && creatorID != userID && !principal.Can(groupID, auth.PermEditAnyTask) {
+		http.Error(w, "Forbidden: only the creator or a group admin can assign others", http.StatusForbidden)
+		return
+	}
+	if completed {
+		http.Error(w, "Task is already completed", http.StatusConflict)
+		return
+	}
+
+	var event string
+	if assign {
+		var role string
+		err := tx.QueryRow(
+			"SELECT role FROM group_memberships WHERE group_id = $1 AND user_id = $2",
+			groupID, req.UserID,
+		).Scan(&role)
+		if err == sql.ErrNoRows {
+			http.Error(w, "User is not a member of the task's group", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Membership lookup failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if auth.Role(role) == auth.RoleViewer {
+			http.Error(w, "Viewers cannot be assigned to tasks", http.StatusBadRequest)
+			return
+		}
+
+		result, err := tx.Exec(
+			`INSERT INTO task_assignees (task_id, user_id, assigned_by)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT DO NOTHING`,
+			req.TaskID, req.UserID, userID,
+		)
+		if err != nil {
+			http.Error(w, "Failed to assign task: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "User is already assigned", http.StatusConflict)
+			return
+		}
+		event = "assigned"
+	} else {
+		result, err := tx.Exec(
+			"DELETE FROM task_assignees WHERE task_id = $1 AND user_id = $2",
+			req.TaskID, req.UserID,
+		)
+		if err != nil {
+			http.Error(w, "Failed to unassign task: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "User is not assigned", http.StatusNotFound)
+			return
+		}
+		event = "unassigned"
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_ = dataflow.InsertTaskEvent(req.TaskID, userID, event)
+
+	assignees, err := loadAssignees(groupID)
+	if err != nil {
+		http.Error(w, "Failed to load assignees: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := assignees[req.TaskID]
+	if list == nil {
+		list = make([]Assignee, 0)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"taskId":    req.TaskID,
+		"assignees": list,
+	})
+}
+
+// AssignTaskHandler handles POST /task/assignees
+func AssignTaskHandler(w http.ResponseWriter, r *http.Request) {
+	changeAssignee(w, r, true)
+}
+
+// UnassignTaskHandler handles DELETE /task/assignees
+func UnassignTaskHandler(w http.ResponseWriter, r *http.Request) {
+	changeAssignee(w, r, false)
+}
@@ -0,0 +1,239 @@
+package task
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"execute/internal"
+
+	"github.com/lib/pq"
+)
+
+// Priority tells how urgent a task is
+type Priority string
+
+const (
+	PriorityNone   Priority = "none"
+	PriorityLow    Priority = "low"
+	PriorityMedium Priority = "medium"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+// Valid reports whether p is one of the priorities above
+func (p Priority) Valid() bool {
+	switch p {
+	case PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent:
+		return true
+	}
+	return false
+}
+
+// DueDate is when a task is due: a point in time, or a whole day if
+// DateOnly is set. In JSON it is "2025-04-20T10:00:00Z" or "2025-04-20",
+// and null (or "" in requests) for a task without a due date.
+type DueDate struct {
+	Time     time.Time
+	DateOnly bool
+}
+
+// parseDueDate reads a date (2025-04-20) or a date-time (RFC 3339). Dates
+// are kept as midnight UTC.
+func parseDueDate(s string) (DueDate, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return DueDate{Time: t, DateOnly: true}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return DueDate{}, fmt.Errorf("%q is neither a date (2025-04-20) nor a date-time (2025-04-20T10:00:00Z)", s)
+	}
+	return DueDate{Time: t}, nil
+}
+
+func (d DueDate) MarshalJSON() ([]byte, error) {
+	switch {
+	case d.Time.IsZero():
+		return []byte("null"), nil
+	case d.DateOnly:
+		return json.Marshal(d.Time.UTC().Format(time.DateOnly))
+	}
+	return json.Marshal(d.Time)
+}
+
+func (d *DueDate) UnmarshalJSON(b []byte) error {
+	var s *string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("dueDate must be a string")
+	}
+	if s == nil || *s == "" {
+		*d = DueDate{}
+		return nil
+	}
+	v, err := parseDueDate(*s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// optionalDueDate is a due date in a request that may leave it out. Set
+// tells a missing dueDate apart from null, which removes the due date.
+type optionalDueDate struct {
+	DueDate
+	Set bool
+}
+
+func (d *optionalDueDate) UnmarshalJSON(b []byte) error {
+	d.Set = true
+	return d.DueDate.UnmarshalJSON(b)
+}
+
+// value returns the due date for tasks.due_date, NULL if there is none
+func (d DueDate) value() any {
+	if d.Time.IsZero() {
+		return nil
+	}
+	return d.Time
+}
+
+// Label is a group label attached to a task
+type Label struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+var errUnknownLabel = errors.New("labels must belong to the task's group")
+
+// loadLabels returns the labels of the group's tasks by task ID
+func loadLabels(groupID int) (map[int][]Label, error) {
+	rows, err := internal.DB.Query(
+		`SELECT tl.task_id, l.id, l.name, l.color
+		   FROM task_labels tl
+		   JOIN labels l ON l.id = tl.label_id
+		  WHERE l.group_id = $1
+		  ORDER BY lower(l.name)`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := make(map[int][]Label)
+	for rows.Next() {
+		var taskID int
+		var l Label
+		if err := rows.Scan(&taskID, &l.ID, &l.Name, &l.Color); err != nil {
+			return nil, err
+		}
+		labels[taskID] = append(labels[taskID], l)
+	}
+	return labels, rows.Err()
+}
+
+// setLabels replaces the labels of a task. It fails with errUnknownLabel if
+// a label is not one of the group's.
+func setLabels(tx *sql.Tx, groupID, taskID int, labelIDs []int) error {
+	ids := make(pq.Int64Array, 0, len(labelIDs))
+	seen := make(map[int]bool)
+	for _, id := range labelIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, int64(id))
+		}
+	}
+
+	var known int
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM labels WHERE group_id = $1 AND id = ANY($2)", groupID, ids,
+	).Scan(&known); err != nil {
+		return err
+	}
+	if known != len(ids) {
+		return errUnknownLabel
+	}
+
+	if _, err := tx.Exec("DELETE FROM task_labels WHERE task_id = $1", taskID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(
+		"INSERT INTO task_labels (task_id, label_id) SELECT $1, unnest($2::int[])", taskID, ids,
+	)
+	return err
+}
+
+// taskFilter narrows down GET /task. Zero values do not filter.
+type taskFilter struct {
+	AssigneeID int
+	Priorities pq.StringArray // Any of these
+	LabelIDs   pq.Int64Array  // All of these
+	DueAfter   *time.Time     // Inclusive
+	DueBefore  *time.Time     // Exclusive
+	HasDueDate *bool
+}
+
+// parseTaskFilter reads the query parameters of GET /task. It returns a
+// message for invalid input.
+func parseTaskFilter(q url.Values, userID int) (taskFilter, string) {
+	f := taskFilter{Priorities: pq.StringArray{}, LabelIDs: pq.Int64Array{}}
+
+	// ?assignee=me or a user ID only lists the tasks assigned to that member
+	if v := q.Get("assignee"); v == "me" {
+		f.AssigneeID = userID
+	} else if v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return f, "assignee must be \"me\" or a user ID"
+		}
+		f.AssigneeID = id
+	}
+
+	if v := q.Get("priority"); v != "" {
+		for _, p := range strings.Split(v, ",") {
+			if !Priority(p).Valid() {
+				return f, "priority must be a list of none, low, medium, high and urgent"
+			}
+			f.Priorities = append(f.Priorities, p)
+		}
+	}
+	if v := q.Get("label"); v != "" {
+		seen := make(map[int64]bool)
+		for _, s := range strings.Split(v, ",") {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id <= 0 {
+				return f, "label must be a list of label IDs"
+			}
+			// The query matches the number of labels, so each counts once
+			if !seen[id] {
+				seen[id] = true
+				f.LabelIDs = append(f.LabelIDs, id)
+			}
+		}
+	}
+
+	for key, dst := range map[string]**time.Time{"dueAfter": &f.DueAfter, "dueBefore": &f.DueBefore} {
+		if v := q.Get(key); v != "" {
+			d, err := parseDueDate(v)
+			if err != nil {
+				return f, key + ": " + err.Error()
+			}
+			*dst = &d.Time
+		}
+	}
+	if v := q.Get("hasDueDate"); v != "" {
+		has, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, "hasDueDate must be true or false"
+		}
+		f.HasDueDate = &has
+	}
+	return f, ""
+}
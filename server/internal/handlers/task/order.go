@@ -0,0 +1,186 @@
+package task
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"execute/internal"
+	"execute/internal/dataflow"
+	"execute/internal/handlers/auth"
+	"execute/internal/handlers/group"
+)
+
+type orderReq struct {
+	TaskID   int `json:"taskId"`
+	BeforeID int `json:"beforeId"` // Put the task just before this one
+	AfterID  int `json:"afterId"`  // or just after this one
+	Version  int `json:"version"`
+}
+
+// moveTarget resolves where a locked task goes: the state, and the ranks of
+// the tasks it ends up between
+type moveTarget func(tx *sql.Tx, groupID int, t *placedTask) (to state, after, before string, err error)
+
+// handleMove moves a task in one transaction and responds with its new
+// place. A version other than 0 must match the task's.
+func handleMove(w http.ResponseWriter, r *http.Request, taskID, version int, eventType string, target moveTarget) {
+	// Get the userID from the auth header
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	// Retrieve the task's group ID to ensure the user belongs to the same group
+	groupID, err := taskGroup(taskID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Task lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Ensure the user is part of the same group
+	if !principal.IsMember(groupID) {
+		http.Error(w, "Forbidden: You are not in the same group as the task", http.StatusForbidden)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermWriteTasks) {
+		return
+	}
+
+	// The whole move, including completion, is one transaction
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the group, then the task, like completion does. Ranks only
+	// change under the group lock.
+	archived, err := group.Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, group.ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+	t, err := lockTask(tx, groupID, taskID)
+	if err != nil {
+		http.Error(w, "Task lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if version != 0 && version != t.Version {
+		http.Error(w,
+			fmt.Sprintf("Task was changed in the meantime (version %d, expected %d), reload and try again", t.Version, version),
+			http.StatusConflict,
+		)
+		return
+	}
+	before := t.place()
+
+	to, afterRank, beforeRank, err := target(tx, groupID, t)
+	if err != nil {
+		writeMoveError(w, err)
+		return
+	}
+	if err := moveTask(tx, groupID, t, to, afterRank, beforeRank); err != nil {
+		writeMoveError(w, err)
+		return
+	}
+	// A move to the task's current place changes nothing
+	moved := t.place() != before
+	if moved {
+		if t.Version, err = bumpVersion(tx, t.ID); err != nil {
+			http.Error(w, "Failed to update task: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	position, err := taskPosition(tx, t.State.ID, t.Rank)
+	if err != nil {
+		http.Error(w, "Failed to update task: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	message := "Task is already in that place"
+	if moved {
+		_ = dataflow.InsertTaskChange(taskID, userID, eventType, before, t.place())
+		message = "Task moved successfully"
+	}
+
+	// Respond with the updated task information
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"taskId":    taskID,
+		"stateId":   t.State.ID,
+		"step":      t.State.Position,
+		"position":  position,
+		"rank":      t.Rank,
+		"completed": t.Completed,
+		"version":   t.Version,
+		"message":   message,
+	})
+}
+
+// ReorderTaskHandler handles PATCH /task/order
+func ReorderTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req orderReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (req.BeforeID == 0) == (req.AfterID == 0) {
+		http.Error(w, "Exactly one of beforeId and afterId is required", http.StatusBadRequest)
+		return
+	}
+	otherID, afterOther := req.BeforeID, false
+	if req.AfterID != 0 {
+		otherID, afterOther = req.AfterID, true
+	}
+	if otherID == req.TaskID {
+		http.Error(w, "A task cannot be placed next to itself", http.StatusBadRequest)
+		return
+	}
+	if req.Version == 0 {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	// The task joins the other task's state, next to it
+	handleMove(w, r, req.TaskID, req.Version, "moved", func(tx *sql.Tx, groupID int, t *placedTask) (state, string, string, error) {
+		var stateID int
+		var rank string
+		err := tx.QueryRow(
+			"SELECT state_id, rank FROM tasks WHERE id = $1 AND group_id = $2", otherID, groupID,
+		).Scan(&stateID, &rank)
+		if err == sql.ErrNoRows {
+			return state{}, "", "", errUnknownTask
+		} else if err != nil {
+			return state{}, "", "", err
+		}
+		to, err := stateByID(tx, groupID, stateID)
+		if err != nil {
+			return state{}, "", "", err
+		}
+		after, before, err := neighboursOf(tx, t, stateID, rank, afterOther)
+		return to, after, before, err
+	})
+}
@@ -0,0 +1,82 @@
+package task
+
+import "strings"
+
+// Ranks order the tasks within a state. They are strings of rankDigits that
+// sort bytewise (tasks.rank uses the C collation) and never end in the
+// lowest digit, so there is always room for another rank between two.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// rankMaxLength is how long ranks may get before the state is rebalanced.
+// Inserting at the same spot over and over makes ranks longer each time.
+const rankMaxLength = 32
+
+// rankBetween returns a rank after a and before b. An empty a stands for the
+// start of the state, an empty b for its end.
+func rankBetween(a, b string) string {
+	if b != "" {
+		// Keep the common prefix, a is read as padded with the lowest digit
+		n := 0
+		for n < len(b) && rankDigit(a, n) == rankDigit(b, n) {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + rankBetween(rankTail(a, n), b[n:])
+		}
+	}
+
+	lo, hi := rankDigit(a, 0), len(rankDigits)
+	if b != "" {
+		hi = rankDigit(b, 0)
+	}
+	if hi-lo > 1 {
+		return string(rankDigits[(lo+hi)/2])
+	}
+	// Neighbouring digits: the first digit of a longer b is already
+	// between the two, otherwise go one digit deeper after a
+	if len(b) > 1 {
+		return b[:1]
+	}
+	return string(rankDigits[lo]) + rankBetween(rankTail(a, 1), "")
+}
+
+// spreadRanks returns n ranks in ascending order, evenly spaced and of at
+// most the same length, with room for about len(rankDigits) ranks between
+// and around them
+func spreadRanks(n int) []string {
+	base := len(rankDigits)
+	width, space := 1, base
+	for space < (n+1)*base {
+		width++
+		space *= base
+	}
+	step := space / (n + 1)
+
+	ranks := make([]string, n)
+	digits := make([]byte, width)
+	for i := range ranks {
+		v := (i + 1) * step
+		for j := width - 1; j >= 0; j-- {
+			digits[j] = rankDigits[v%base]
+			v /= base
+		}
+		// Trailing zeros go, which keeps the order
+		ranks[i] = strings.TrimRight(string(digits), rankDigits[:1])
+	}
+	return ranks
+}
+
+// rankDigit returns the value of the i-th digit of r, 0 past its end
+func rankDigit(r string, i int) int {
+	if i >= len(r) {
+		return 0
+	}
+	return strings.IndexByte(rankDigits, r[i])
+}
+
+func rankTail(r string, i int) string {
+	if i >= len(r) {
+		return ""
+	}
+	return r[i:]
+}
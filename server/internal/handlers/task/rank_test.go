@@ -0,0 +1,106 @@
+package task
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// checkRank fails unless r is a valid rank strictly between a and b
+func checkRank(t *testing.T, a, b, r string) {
+	t.Helper()
+	if r == "" || strings.HasSuffix(r, "0") {
+		t.Fatalf("rankBetween(%q, %q) = %q, want a non-empty rank not ending in '0'", a, b, r)
+	}
+	if strings.Trim(r, rankDigits) != "" {
+		t.Fatalf("rankBetween(%q, %q) = %q, has characters outside rankDigits", a, b, r)
+	}
+	if r <= a || (b != "" && r >= b) {
+		t.Fatalf("rankBetween(%q, %q) = %q, not between", a, b, r)
+	}
+}
+
+func TestRankBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"", ""},
+		{"", "1"},
+		{"", "01"},
+		{"", "0000000001"},
+		{"1", ""},
+		{"z", ""},
+		{"zzz", ""},
+		{"1", "2"},
+		{"1", "3"},
+		{"1", "11"},
+		{"1", "101"},
+		{"0f", "1"},
+		{"0z", "1"},
+		{"a", "a1"},
+		{"az", "b"},
+		{"az", "b01"},
+		{"00000000011", "00000000021"},
+		{"i", "j"},
+		{"yz", "z"},
+	}
+	for _, tt := range tests {
+		checkRank(t, tt.a, tt.b, rankBetween(tt.a, tt.b))
+	}
+}
+
+func TestRankBetweenRepeated(t *testing.T) {
+	tests := []struct {
+		name string
+		spot func(n int) int // Index to insert at in a list of n ranks
+	}{
+		{"front", func(n int) int { return 0 }},
+		{"back", func(n int) int { return n }},
+		{"middle", func(n int) int { return n / 2 }},
+		{"after first", func(n int) int { return min(n, 1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ranks []string
+			for range 500 {
+				i := tt.spot(len(ranks))
+				var a, b string
+				if i > 0 {
+					a = ranks[i-1]
+				}
+				if i < len(ranks) {
+					b = ranks[i]
+				}
+				r := rankBetween(a, b)
+				checkRank(t, a, b, r)
+				ranks = append(ranks[:i], append([]string{r}, ranks[i:]...)...)
+			}
+			if !sort.StringsAreSorted(ranks) {
+				t.Fatal("ranks are out of order")
+			}
+		})
+	}
+}
+
+func TestSpreadRanks(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 35, 36, 100, 1295, 1296, 10000} {
+		ranks := spreadRanks(n)
+		if len(ranks) != n {
+			t.Fatalf("spreadRanks(%d) returned %d ranks", n, len(ranks))
+		}
+		for i, r := range ranks {
+			var prev string
+			if i > 0 {
+				prev = ranks[i-1]
+			}
+			if r <= prev || strings.HasSuffix(r, "0") || len(r) > rankMaxLength {
+				t.Fatalf("spreadRanks(%d)[%d] = %q after %q", n, i, r, prev)
+			}
+		}
+		// There is room before the first and after the last rank
+		if n > 0 {
+			checkRank(t, "", ranks[0], rankBetween("", ranks[0]))
+			checkRank(t, ranks[n-1], "", rankBetween(ranks[n-1], ""))
+		}
+	}
+}
@@ -10,29 +10,39 @@ import (
 	"execute/internal"
 	"execute/internal/dataflow"
 	"execute/internal/handlers/auth"
-	"execute/internal/handlers/user"
+	"execute/internal/handlers/group"
 )
 
 type Task struct {
-	ID              int       `json:"id"`
-	GroupID         int       `json:"groupId"`
-	CreatorUserID   int       `json:"creatorUserId"`
-	CreatorUsername string    `json:"creatorUsername"`
-	CreationDate    time.Time `json:"creationDate"`
-	DueDate         time.Time `json:"dueDate"`
-	Name            string    `json:"name"`
-	Description     string    `json:"description"`
-	PointsValue     int       `json:"pointsValue"`
-	Step            int       `json:"step"`
-	Completed       bool      `json:"completed"`
+	ID              int        `json:"id"`
+	GroupID         int        `json:"groupId"`
+	CreatorUserID   int        `json:"creatorUserId"`
+	CreatorUsername string     `json:"creatorUsername"`
+	CreationDate    time.Time  `json:"creationDate"`
+	DueDate         DueDate    `json:"dueDate"`
+	Priority        Priority   `json:"priority"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	PointsValue     int        `json:"pointsValue"`
+	Step            int        `json:"step"` // Position of the state on the board
+	StateID         int        `json:"stateId"`
+	Position        int        `json:"position"` // Order within the state, starting at 1
+	Rank            string     `json:"rank"`     // Sorts the tasks of a state, see PATCH /task/order
+	Version         int        `json:"version"`  // Changes with every edit, see PATCH /task
+	Completed       bool       `json:"completed"`
+	Assignees       []Assignee `json:"assignees"`
+	Labels          []Label    `json:"labels"`
 }
 
 type createReq struct {
-	DueDate     time.Time `json:"dueDate"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	PointsValue int       `json:"pointsValue"`
-	Step        int       `json:"step"`
+	DueDate     DueDate  `json:"dueDate"` // Optional
+	Priority    Priority `json:"priority"`
+	LabelIDs    []int    `json:"labelIds"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	PointsValue int      `json:"pointsValue"`
+	Step        int      `json:"step"`    // Board position of the initial state
+	StateID     int      `json:"stateId"` // Takes precedence over step
 }
 
 type deleteReq struct {
@@ -40,16 +50,22 @@ type deleteReq struct {
 }
 
 type updateTaskReq struct {
-	TaskID      int       `json:"taskId"`
-	DueDate     time.Time `json:"dueDate"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	PointsValue int       `json:"pointsValue"`
+	TaskID      int             `json:"taskId"`
+	DueDate     optionalDueDate `json:"dueDate"`  // Unchanged if missing, null removes it
+	Priority    *Priority       `json:"priority"` // Unchanged if missing
+	LabelIDs    *[]int          `json:"labelIds"` // Unchanged if missing
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	PointsValue int             `json:"pointsValue"`
 }
 
 type StepUpdateReq struct {
-	TaskID int    `json:"taskId"`
-	Action string `json:"action"`
+	TaskID   int    `json:"taskId"`
+	StateID  int    `json:"stateId"`  // Target state
+	Step     int    `json:"step"`     // Board position of the target state, if stateId is missing
+	Position int    `json:"position"` // 1-based position within the state, last if missing
+	Version  int    `json:"version"`  // Version of the task the move was based on
+	Action   string `json:"action"`   // Deprecated "+1" or "-1", moves to the end of the neighbouring state
 }
 
 type completionReq struct {
@@ -65,18 +81,22 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate user
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	userID := principal.UserID
 
 	// Get user's group
-	groupID, err := user.GetUserGroupID(userID)
+	groupID, err := principal.Group()
 	if err != nil {
 		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusForbidden)
 		return
 	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermWriteTasks) {
+		return
+	}
 
 	// Decode request
 	var req createReq
@@ -88,6 +108,13 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Name required and points must be ≥0", http.StatusBadRequest)
 		return
 	}
+	if req.Priority == "" {
+		req.Priority = PriorityNone
+	}
+	if !req.Priority.Valid() {
+		http.Error(w, "priority must be none, low, medium, high or urgent", http.StatusBadRequest)
+		return
+	}
 
 	// Start transaction
 	tx, err := internal.DB.Begin()
@@ -97,7 +124,18 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Lock and check group's point pool
+	// Lock the group, archived groups take no new tasks
+	archived, err := group.Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, group.ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	// Check the group's point pool
 	var poolPoints int
 	if err := tx.QueryRow(
 		"SELECT points FROM groups WHERE id = $1 FOR UPDATE",
@@ -114,6 +152,25 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// New tasks start in the first state unless another is chosen
+	var st state
+	if req.StateID != 0 {
+		st, err = stateByID(tx, groupID, req.StateID)
+	} else {
+		st, err = stateAt(tx, groupID, max(req.Step, 1))
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Unknown workflow state", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch workflow state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := checkWIP(tx, st); err != nil {
+		writeMoveError(w, err)
+		return
+	}
+
 	// Deduct points
 	if _, err := tx.Exec(
 		"UPDATE groups SET points = points - $1 WHERE id = $2",
@@ -123,18 +180,45 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert task
+	// Insert task, last in its state
+	rank, err := lastRank(tx, st.ID)
+	if err != nil {
+		http.Error(w, "Failed to rank task: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	var taskID int
 	if err := tx.QueryRow(
 		`INSERT INTO tasks
-		   (group_id, creator_user_id, due_date, name, description, points_value, step)
-		 VALUES ($1,$2,$3,$4,$5,$6,$7)
+		   (group_id, creator_user_id, due_date, due_date_only, priority, name, description, points_value, step, state_id, rank)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
 		 RETURNING id`,
-		groupID, userID, req.DueDate, req.Name, req.Description, req.PointsValue, req.Step,
+		groupID, userID, req.DueDate.value(), req.DueDate.DateOnly, req.Priority,
+		req.Name, req.Description, req.PointsValue, st.Position, st.ID, rank,
 	).Scan(&taskID); err != nil {
 		http.Error(w, "Failed to create task: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if _, err := shortenRank(tx, st.ID, taskID, rank); err != nil {
+		http.Error(w, "Failed to rank task: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(req.LabelIDs) > 0 {
+		if err := setLabels(tx, groupID, taskID, req.LabelIDs); err == errUnknownLabel {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to label task: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// A task created in a done state is completed right away
+	if st.Done {
+		if err := setCompletion(tx, groupID, taskID, req.PointsValue, true); err != nil {
+			writeMoveError(w, err)
+			return
+		}
+	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
@@ -144,53 +228,70 @@ func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 
 	_ = dataflow.InsertTaskEvent(taskID, userID, "created")
 
-	// Fetch creator username and respond
-	var username string
-	if err := internal.DB.QueryRow(
-		"SELECT username FROM users WHERE id=$1", userID,
-	).Scan(&username); err != nil {
-		http.Error(w, "Failed to retrieve username: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]any{
 		"id":              taskID,
-		"creatorUsername": username,
+		"creatorUsername": principal.Username,
 	})
 }
 
 // ListTasksHandler handles GET /task
 func ListTasksHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	groupID, err := user.GetUserGroupID(userID)
+	groupID, err := principal.Group()
 	if err != nil {
 		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusForbidden)
 		return
 	}
 
+	filter, msg := parseTaskFilter(r.URL.Query(), principal.UserID)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
 	rows, err := internal.DB.Query(
 		`SELECT
 		  t.id,
 		  t.group_id,
-		  t.creator_user_id,
-		  u.username,
+		  COALESCE(t.creator_user_id, 0),
+		  COALESCE(u.username, 'Deleted user'),
 		  t.creation_date,
 		  t.due_date,
+		  t.due_date_only,
+		  t.priority,
 		  t.name,
 		  t.description,
 		  t.points_value,
 		  t.step,
-          t.completed
+		  t.state_id,
+		  p.position,
+		  t.rank,
+		  t.version,
+		  t.completed
 		FROM tasks t
-		JOIN users u ON u.id = t.creator_user_id
-		WHERE t.group_id = $1`,
-		groupID,
+		JOIN workflow_states w ON w.id = t.state_id
+		JOIN (SELECT id, ROW_NUMBER() OVER (PARTITION BY state_id ORDER BY rank) AS position
+		        FROM tasks
+		       WHERE group_id = $1) p ON p.id = t.id
+		LEFT JOIN users u ON u.id = t.creator_user_id
+		WHERE t.group_id = $1
+		  AND ($2 = 0 OR EXISTS (
+		      SELECT 1 FROM task_assignees a WHERE a.task_id = t.id AND a.user_id = $2))
+		  AND (cardinality($3::text[]) = 0 OR t.priority = ANY($3))
+		  AND (SELECT COUNT(*) FROM task_labels l WHERE l.task_id = t.id AND l.label_id = ANY($4::int[]))
+		      = cardinality($4::int[])
+		  AND ($5::timestamptz IS NULL OR t.due_date >= $5)
+		  AND ($6::timestamptz IS NULL OR t.due_date < $6)
+		  AND ($7::boolean IS NULL OR (t.due_date IS NOT NULL) = $7)
+		ORDER BY w.position, t.rank`,
+		groupID, filter.AssigneeID, filter.Priorities, filter.LabelIDs,
+		filter.DueAfter, filter.DueBefore, filter.HasDueDate,
 	)
 	if err != nil {
 		http.Error(w, "Failed to fetch tasks", http.StatusInternalServerError)
@@ -198,25 +299,52 @@ func ListTasksHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	assignees, err := loadAssignees(groupID)
+	if err != nil {
+		http.Error(w, "Failed to fetch assignees", http.StatusInternalServerError)
+		return
+	}
+	labels, err := loadLabels(groupID)
+	if err != nil {
+		http.Error(w, "Failed to fetch labels", http.StatusInternalServerError)
+		return
+	}
+
 	var tasks []Task
 	for rows.Next() {
 		var t Task
+		var due sql.NullTime
 		if err := rows.Scan(
 			&t.ID,
 			&t.GroupID,
 			&t.CreatorUserID,
 			&t.CreatorUsername,
 			&t.CreationDate,
-			&t.DueDate,
+			&due,
+			&t.DueDate.DateOnly,
+			&t.Priority,
 			&t.Name,
 			&t.Description,
 			&t.PointsValue,
 			&t.Step,
+			&t.StateID,
+			&t.Position,
+			&t.Rank,
+			&t.Version,
 			&t.Completed,
 		); err != nil {
 			http.Error(w, "Failed to scan task", http.StatusInternalServerError)
 			return
 		}
+		t.DueDate.Time = due.Time
+		t.Assignees = assignees[t.ID]
+		if t.Assignees == nil {
+			t.Assignees = make([]Assignee, 0)
+		}
+		t.Labels = labels[t.ID]
+		if t.Labels == nil {
+			t.Labels = make([]Label, 0)
+		}
 		tasks = append(tasks, t)
 	}
 
@@ -231,11 +359,12 @@ func UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	userID := principal.UserID
 
 	var req updateTaskReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -246,11 +375,15 @@ func UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Name required and points must be ≥0", http.StatusBadRequest)
 		return
 	}
+	if req.Priority != nil && !req.Priority.Valid() {
+		http.Error(w, "priority must be none, low, medium, high or urgent", http.StatusBadRequest)
+		return
+	}
 
-	var creatorID int
+	var groupID, creatorID int
 	err = internal.DB.QueryRow(
-		"SELECT creator_user_id FROM tasks WHERE id=$1", req.TaskID,
-	).Scan(&creatorID)
+		"SELECT group_id, COALESCE(creator_user_id, 0) FROM tasks WHERE id=$1", req.TaskID,
+	).Scan(&groupID, &creatorID)
 	if err == sql.ErrNoRows {
 		http.Error(w, "Task not found", http.StatusNotFound)
 		return
@@ -258,24 +391,61 @@ func UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Lookup failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if creatorID != userID {
-		http.Error(w, "Forbidden: only the creator can edit", http.StatusForbidden)
+	if !auth.RequirePermission(w, principal, groupID, auth.PermWriteTasks) {
+		return
+	}
+	if creatorID != userID && !principal.Can(groupID, auth.PermEditAnyTask) {
+		http.Error(w, "Forbidden: only the creator or a group admin can edit", http.StatusForbidden)
 		return
 	}
 
-	_, err = internal.DB.Exec(
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	archived, err := group.Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, group.ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
+	_, err = tx.Exec(
 		`UPDATE tasks
 		    SET name=$1,
 		        description=$2,
-		        due_date=$3,
-		        points_value=$4
-		  WHERE id=$5`,
-		req.Name, req.Description, req.DueDate, req.PointsValue, req.TaskID,
+		        due_date=CASE WHEN $8 THEN $3 ELSE due_date END,
+		        due_date_only=CASE WHEN $8 THEN $4 ELSE due_date_only END,
+		        priority=COALESCE($5, priority),
+		        points_value=$6,
+		        version=version+1
+		  WHERE id=$7`,
+		req.Name, req.Description, req.DueDate.value(), req.DueDate.DateOnly, req.Priority, req.PointsValue, req.TaskID,
+		req.DueDate.Set,
 	)
 	if err != nil {
 		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if req.LabelIDs != nil {
+		if err := setLabels(tx, groupID, req.TaskID, *req.LabelIDs); err == errUnknownLabel {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	_ = dataflow.InsertTaskEvent(req.TaskID, userID, "updated")
 
@@ -283,7 +453,7 @@ func UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, `{"message":"Task updated successfully"}`)
 }
 
-// UpdateStepHandler handles PATCH /task
+// TaskStepHandler handles PATCH /task
 func TaskStepHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPatch {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -297,82 +467,53 @@ func TaskStepHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate the action
-	if req.Action != "+1" && req.Action != "-1" {
+	// Validate the target
+	legacy := req.Action != ""
+	if legacy && req.Action != "+1" && req.Action != "-1" {
 		http.Error(w, "Invalid action. Must be '+1' or '-1'", http.StatusBadRequest)
 		return
 	}
-
-	// Get the userID from the auth header
-	userID, err := auth.GetUserID(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-
-	// Retrieve the groupID for the user
-	groupID, err := user.GetUserGroupID(userID)
-	if err != nil {
-		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusForbidden)
-		return
-	}
-
-	// Retrieve the task's group ID to ensure the user belongs to the same group
-	var taskGroupID, currentStep int
-	err = internal.DB.QueryRow(
-		"SELECT group_id, step FROM tasks WHERE id=$1", req.TaskID,
-	).Scan(&taskGroupID, &currentStep)
-	if err == sql.ErrNoRows {
-		http.Error(w, "Task not found", http.StatusNotFound)
-		return
-	} else if err != nil {
-		http.Error(w, "Task lookup failed: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Ensure the user is part of the same group
-	if taskGroupID != groupID {
-		http.Error(w, "Forbidden: You are not in the same group as the task", http.StatusForbidden)
-		return
-	}
-
-	// Determine the step update
-	var stepChange int
-	if req.Action == "+1" {
-		stepChange = 1
-	} else if req.Action == "-1" {
-		stepChange = -1
-	}
-
-	// Update the step of the task in the database
-	_, err = internal.DB.Exec(
-		`UPDATE tasks
-		 SET step = step + $1
-		 WHERE id = $2`,
-		stepChange, req.TaskID,
-	)
-	if err != nil {
-		http.Error(w, "Failed to update step: "+err.Error(), http.StatusInternalServerError)
-		return
+	if !legacy {
+		if req.StateID == 0 && req.Step == 0 {
+			http.Error(w, "stateId or step is required", http.StatusBadRequest)
+			return
+		}
+		if req.Version == 0 {
+			http.Error(w, "version is required", http.StatusBadRequest)
+			return
+		}
 	}
 
-	_ = dataflow.InsertTaskEvent(req.TaskID, userID, "step_changed")
-
-	// Retrieve the updated step value
-	err = internal.DB.QueryRow(
-		"SELECT step FROM tasks WHERE id=$1", req.TaskID,
-	).Scan(&currentStep)
-	if err != nil {
-		http.Error(w, "Failed to retrieve updated step value: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+	eventType := "moved"
+	if legacy {
+		eventType = "step_changed"
+	}
+	handleMove(w, r, req.TaskID, req.Version, eventType, func(tx *sql.Tx, groupID int, t *placedTask) (state, string, string, error) {
+		var to state
+		var err error
+		switch {
+		case legacy && req.Action == "+1":
+			to, err = stateAt(tx, groupID, t.State.Position+1)
+		case legacy:
+			to, err = stateAt(tx, groupID, t.State.Position-1)
+		case req.StateID != 0:
+			to, err = stateByID(tx, groupID, req.StateID)
+		default:
+			to, err = stateAt(tx, groupID, req.Step)
+		}
+		if err == sql.ErrNoRows {
+			return to, "", "", errUnknownState
+		} else if err != nil {
+			return to, "", "", err
+		}
 
-	// Respond with the updated task information
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]any{
-		"taskId":  req.TaskID,
-		"step":    currentStep,
-		"message": "Task step updated successfully",
+		// The legacy actions put the task last
+		position := req.Position
+		if legacy {
+			position = 0
+		}
+		after, before, err := neighboursAt(tx, t, to.ID, position)
+		return to, after, before, err
 	})
 }
 
@@ -391,16 +532,27 @@ func ToggleTaskCompletionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate user
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	userID := principal.UserID
 
-	// Get user's group
-	groupID, err := user.GetUserGroupID(userID)
-	if err != nil {
-		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusForbidden)
+	// The task's group, which may be another of the user's groups than the selected one
+	groupID, err := taskGroup(req.TaskID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Task lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !principal.IsMember(groupID) {
+		http.Error(w, "Forbidden: You are not in the same group as the task", http.StatusForbidden)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermWriteTasks) {
 		return
 	}
 
@@ -412,23 +564,29 @@ func ToggleTaskCompletionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Lock and fetch group
-	var poolPoints int
-	if err := tx.QueryRow(
-		"SELECT points FROM groups WHERE id = $1 FOR UPDATE",
-		groupID,
-	).Scan(&poolPoints); err != nil {
-		http.Error(w, "Failed to fetch points pool: "+err.Error(), http.StatusInternalServerError)
+	// Lock group, setCompletion checks the pool
+	archived, err := group.Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, group.ErrArchived.Error(), http.StatusConflict)
 		return
 	}
 
 	// Load task details and current completion flag
 	var taskGroupID, taskPointsVal int
-	var currentCompleted bool
+	var currentCompleted, inDoneState bool
+	var stateName string
 	if err := tx.QueryRow(
-		"SELECT group_id, points_value, completed FROM tasks WHERE id=$1 FOR UPDATE",
+		`SELECT t.group_id, t.points_value, t.completed, w.name, w.is_done
+		   FROM tasks t
+		   JOIN workflow_states w ON w.id = t.state_id
+		  WHERE t.id=$1
+		    FOR UPDATE OF t`,
 		req.TaskID,
-	).Scan(&taskGroupID, &taskPointsVal, &currentCompleted); err != nil {
+	).Scan(&taskGroupID, &taskPointsVal, &currentCompleted, &stateName, &inDoneState); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Task not found", http.StatusNotFound)
 		} else {
@@ -450,37 +608,22 @@ func ToggleTaskCompletionHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Task is not completed", http.StatusBadRequest)
 		return
 	}
-
-	// Credit/debit group pool and update group score
-	if req.Completed {
-		// mark complete: return points & credit group score
-		if _, err := tx.Exec(
-			"UPDATE groups SET points = points + $1, points_score = points_score + $1 WHERE id = $2",
-			taskPointsVal, groupID,
-		); err != nil {
-			http.Error(w, "Failed to update group points and score: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		// undo complete: take points & debit group score
-		if poolPoints < taskPointsVal {
-			http.Error(w, "Not enough points in pool to undo completion", http.StatusBadRequest)
-			return
-		}
-		if _, err := tx.Exec(
-			"UPDATE groups SET points = points - $1, points_score = points_score - $1 WHERE id = $2",
-			taskPointsVal, groupID,
-		); err != nil {
-			http.Error(w, "Failed to update group points and score: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Tasks in a done state stay completed until they are moved out of it
+	if !req.Completed && inDoneState {
+		http.Error(w,
+			fmt.Sprintf("Task is in the done state %q, move it out of it to undo the completion", stateName),
+			http.StatusConflict,
+		)
+		return
 	}
 
-	// Update task.completed flag
-	if _, err := tx.Exec(
-		"UPDATE tasks SET completed = $1 WHERE id = $2",
-		req.Completed, req.TaskID,
-	); err != nil {
+	// Credit/debit group pool, score and assignees
+	if err := setCompletion(tx, groupID, req.TaskID, taskPointsVal, req.Completed); err != nil {
+		writeMoveError(w, err)
+		return
+	}
+	version, err := bumpVersion(tx, req.TaskID)
+	if err != nil {
 		http.Error(w, "Failed to update completion: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -497,6 +640,7 @@ func ToggleTaskCompletionHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]any{
 		"taskId":    req.TaskID,
 		"completed": req.Completed,
+		"version":   version,
 		"message":   "Task completion status updated successfully",
 	})
 }
@@ -509,11 +653,12 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate user
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	userID := principal.UserID
 
 	// Decode JSON body
 	var req deleteReq
@@ -522,10 +667,20 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Lookup groupID
-	groupID, err := user.GetUserGroupID(userID)
-	if err != nil {
-		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusForbidden)
+	// Lookup the task's group
+	groupID, err := taskGroup(req.TaskID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Task lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !principal.IsMember(groupID) {
+		http.Error(w, "Forbidden: task does not belong to your group", http.StatusForbidden)
+		return
+	}
+	if !auth.RequirePermission(w, principal, groupID, auth.PermWriteTasks) {
 		return
 	}
 
@@ -537,7 +692,17 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	// Lock and fetch group pool
+	// Lock the group, then fetch its pool
+	archived, err := group.Archived(tx, groupID)
+	if err != nil {
+		http.Error(w, "Group lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if archived {
+		http.Error(w, group.ErrArchived.Error(), http.StatusConflict)
+		return
+	}
+
 	var poolPoints int
 	if err := tx.QueryRow(
 		"SELECT points FROM groups WHERE id = $1 FOR UPDATE",
@@ -551,7 +716,7 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 	var taskGroupID, creatorID, pointsVal int
 	var completed bool
 	err = tx.QueryRow(
-		`SELECT group_id, creator_user_id, points_value, completed
+		`SELECT group_id, COALESCE(creator_user_id, 0), points_value, completed
 		   FROM tasks
 		  WHERE id = $1
 		    FOR UPDATE`,
@@ -565,9 +730,9 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Permission check: only creator can delete
-	if creatorID != userID {
-		http.Error(w, "Forbidden: only the creator can delete", http.StatusForbidden)
+	// Permission check: only the creator or a group admin can delete
+	if creatorID != userID && !principal.Can(groupID, auth.PermEditAnyTask) {
+		http.Error(w, "Forbidden: only the creator or a group admin can delete", http.StatusForbidden)
 		return
 	}
 
@@ -624,3 +789,13 @@ func DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 			}()),
 	})
 }
+
+// taskGroup returns the ID of the group a task belongs to. Tasks never move
+// between groups, so the result can be used to lock the group before the task.
+func taskGroup(taskID int) (int, error) {
+	var groupID int
+	err := internal.DB.QueryRow(
+		"SELECT group_id FROM tasks WHERE id = $1", taskID,
+	).Scan(&groupID)
+	return groupID, err
+}
@@ -0,0 +1,342 @@
+package task
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+var (
+	errNotEnoughPoints = errors.New("not enough points in pool to undo completion")
+	errTransition      = errors.New("transition not allowed")
+	errWIPLimit        = errors.New("WIP limit reached")
+	errUnknownState    = errors.New("unknown workflow state")
+	errUnknownTask     = errors.New("the other task is not in the group")
+)
+
+// state is a column of a group's workflow
+type state struct {
+	ID       int
+	Name     string
+	Position int
+	WIPLimit sql.NullInt64
+	Done     bool
+}
+
+const stateColumns = "id, name, position, wip_limit, is_done"
+
+func scanState(row *sql.Row) (state, error) {
+	var s state
+	err := row.Scan(&s.ID, &s.Name, &s.Position, &s.WIPLimit, &s.Done)
+	return s, err
+}
+
+// stateByID returns a state of the group, sql.ErrNoRows if there is none
+func stateByID(tx *sql.Tx, groupID, id int) (state, error) {
+	return scanState(tx.QueryRow(
+		"SELECT "+stateColumns+" FROM workflow_states WHERE id = $1 AND group_id = $2", id, groupID,
+	))
+}
+
+// stateAt returns the state at a 1-based board position, sql.ErrNoRows if there is none
+func stateAt(tx *sql.Tx, groupID, position int) (state, error) {
+	return scanState(tx.QueryRow(
+		"SELECT "+stateColumns+" FROM workflow_states WHERE group_id = $1 AND position = $2", groupID, position,
+	))
+}
+
+// checkWIP fails with errWIPLimit if the state cannot take another task
+func checkWIP(tx *sql.Tx, s state) error {
+	if !s.WIPLimit.Valid {
+		return nil
+	}
+	var tasks int64
+	if err := tx.QueryRow("SELECT COUNT(*) FROM tasks WHERE state_id = $1", s.ID).Scan(&tasks); err != nil {
+		return err
+	}
+	if tasks >= s.WIPLimit.Int64 {
+		return fmt.Errorf("%w: %q holds at most %d tasks", errWIPLimit, s.Name, s.WIPLimit.Int64)
+	}
+	return nil
+}
+
+// setCompletion completes a task or undoes it. Completing returns the points
+// to the pool, credits the group's score and splits them between the
+// assignees; undoing takes all of that back. The caller has locked the group
+// and the task.
+func setCompletion(tx *sql.Tx, groupID, taskID, points int, completed bool) error {
+	if completed {
+		if _, err := tx.Exec(
+			"UPDATE groups SET points = points + $1, points_score = points_score + $1 WHERE id = $2",
+			points, groupID,
+		); err != nil {
+			return err
+		}
+		if err := creditAssignees(tx, groupID, taskID, points); err != nil {
+			return err
+		}
+	} else {
+		var poolPoints int
+		if err := tx.QueryRow("SELECT points FROM groups WHERE id = $1", groupID).Scan(&poolPoints); err != nil {
+			return err
+		}
+		if poolPoints < points {
+			return errNotEnoughPoints
+		}
+		if _, err := tx.Exec(
+			"UPDATE groups SET points = points - $1, points_score = points_score - $1 WHERE id = $2",
+			points, groupID,
+		); err != nil {
+			return err
+		}
+		if err := debitAssignees(tx, taskID); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("UPDATE tasks SET completed = $1 WHERE id = $2", completed, taskID)
+	return err
+}
+
+// placedTask is a task locked for a move, with its place on the board
+type placedTask struct {
+	ID        int
+	State     state
+	Rank      string
+	Points    int
+	Completed bool
+	Version   int
+}
+
+// place is where a task sits, as recorded in task events
+type place struct {
+	StateID   int    `json:"stateId"`
+	Step      int    `json:"step"`
+	Rank      string `json:"rank"`
+	Completed bool   `json:"completed"`
+}
+
+func (t *placedTask) place() place {
+	return place{StateID: t.State.ID, Step: t.State.Position, Rank: t.Rank, Completed: t.Completed}
+}
+
+// lockTask locks a task of the group for a move. The caller has locked the group.
+func lockTask(tx *sql.Tx, groupID, taskID int) (*placedTask, error) {
+	t := &placedTask{ID: taskID}
+	var stateID int
+	if err := tx.QueryRow(
+		`SELECT state_id, rank, points_value, completed, version
+		   FROM tasks
+		  WHERE id = $1 AND group_id = $2
+		    FOR UPDATE`,
+		taskID, groupID,
+	).Scan(&stateID, &t.Rank, &t.Points, &t.Completed, &t.Version); err != nil {
+		return nil, err
+	}
+	s, err := stateByID(tx, groupID, stateID)
+	if err != nil {
+		return nil, err
+	}
+	t.State = s
+	return t, nil
+}
+
+// lastRank returns the rank after the last task of a state
+func lastRank(tx *sql.Tx, stateID int) (string, error) {
+	var last string
+	err := tx.QueryRow("SELECT COALESCE(MAX(rank), '') FROM tasks WHERE state_id = $1", stateID).Scan(&last)
+	return rankBetween(last, ""), err
+}
+
+// rebalanceRanks gives the tasks of a state evenly spaced, short ranks in
+// their current order. The caller has locked the group.
+func rebalanceRanks(tx *sql.Tx, stateID int) error {
+	rows, err := tx.Query("SELECT id FROM tasks WHERE state_id = $1 ORDER BY rank", stateID)
+	if err != nil {
+		return err
+	}
+	var ids pq.Int64Array
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// The unique index is checked row by row, so the old ranks are moved out
+	// of the way first. '~' sorts after all rank digits.
+	if _, err := tx.Exec("UPDATE tasks SET rank = '~' || id WHERE state_id = $1", stateID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE tasks t
+		    SET rank = r.rank
+		   FROM unnest($1::int[], $2::text[]) AS r (id, rank)
+		  WHERE t.id = r.id`,
+		ids, pq.StringArray(spreadRanks(len(ids))),
+	)
+	return err
+}
+
+// shortenRank rebalances the state if a task's new rank is too long and
+// returns the task's rank afterwards
+func shortenRank(tx *sql.Tx, stateID, taskID int, rank string) (string, error) {
+	if len(rank) <= rankMaxLength {
+		return rank, nil
+	}
+	if err := rebalanceRanks(tx, stateID); err != nil {
+		return "", err
+	}
+	err := tx.QueryRow("SELECT rank FROM tasks WHERE id = $1", taskID).Scan(&rank)
+	return rank, err
+}
+
+// neighboursAt returns the ranks around a 1-based position within a state,
+// leaving out the task itself. Positions past the end, or not above 0, are
+// after the last task. An empty rank means there is no task on that side.
+func neighboursAt(tx *sql.Tx, t *placedTask, stateID, position int) (after, before string, err error) {
+	if position == 1 {
+		err = tx.QueryRow(
+			"SELECT COALESCE(MIN(rank), '') FROM tasks WHERE state_id = $1 AND id <> $2", stateID, t.ID,
+		).Scan(&before)
+		return "", before, err
+	}
+	if position > 1 {
+		rows, err := tx.Query(
+			"SELECT rank FROM tasks WHERE state_id = $1 AND id <> $2 ORDER BY rank OFFSET $3 LIMIT 2",
+			stateID, t.ID, position-2,
+		)
+		if err != nil {
+			return "", "", err
+		}
+		defer rows.Close()
+		var ranks []string
+		for rows.Next() {
+			var r string
+			if err := rows.Scan(&r); err != nil {
+				return "", "", err
+			}
+			ranks = append(ranks, r)
+		}
+		if err := rows.Err(); err != nil {
+			return "", "", err
+		}
+		switch len(ranks) {
+		case 2:
+			return ranks[0], ranks[1], nil
+		case 1:
+			return ranks[0], "", nil
+		}
+	}
+	err = tx.QueryRow(
+		"SELECT COALESCE(MAX(rank), '') FROM tasks WHERE state_id = $1 AND id <> $2", stateID, t.ID,
+	).Scan(&after)
+	return after, "", err
+}
+
+// neighboursOf returns the ranks around the spot just before or after
+// another task of the same state, leaving out the task itself
+func neighboursOf(tx *sql.Tx, t *placedTask, stateID int, otherRank string, afterOther bool) (after, before string, err error) {
+	if afterOther {
+		err = tx.QueryRow(
+			"SELECT COALESCE(MIN(rank), '') FROM tasks WHERE state_id = $1 AND id <> $2 AND rank > $3",
+			stateID, t.ID, otherRank,
+		).Scan(&before)
+		return otherRank, before, err
+	}
+	err = tx.QueryRow(
+		"SELECT COALESCE(MAX(rank), '') FROM tasks WHERE state_id = $1 AND id <> $2 AND rank < $3",
+		stateID, t.ID, otherRank,
+	).Scan(&after)
+	return after, otherRank, err
+}
+
+// moveTask moves a task to a state of its group, between the tasks ranked
+// after and before (see neighboursAt). Only the task's row changes, unless
+// its rank gets too long and the state is rebalanced. Moving
+// into a done state completes the task, moving out of one undoes the
+// completion. t is updated to the new place.
+func moveTask(tx *sql.Tx, groupID int, t *placedTask, to state, after, before string) error {
+	from := t.State
+	if to.ID != from.ID {
+		var allowed bool
+		if err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM workflow_transitions WHERE from_state_id = $1 AND to_state_id = $2)",
+			from.ID, to.ID,
+		).Scan(&allowed); err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %q to %q", errTransition, from.Name, to.Name)
+		}
+		if err := checkWIP(tx, to); err != nil {
+			return err
+		}
+	} else if after < t.Rank && (before == "" || t.Rank < before) {
+		// Already in that spot
+		return nil
+	}
+
+	rank := rankBetween(after, before)
+	_, err := tx.Exec(
+		"UPDATE tasks SET state_id = $1, step = $2, rank = $3 WHERE id = $4",
+		to.ID, to.Position, rank, t.ID,
+	)
+	if err != nil {
+		return err
+	}
+	t.State = to
+	if t.Rank, err = shortenRank(tx, to.ID, t.ID, rank); err != nil {
+		return err
+	}
+
+	switch {
+	case to.Done && !t.Completed:
+		t.Completed = true
+		return setCompletion(tx, groupID, t.ID, t.Points, true)
+	case !to.Done && from.Done && t.Completed:
+		t.Completed = false
+		return setCompletion(tx, groupID, t.ID, t.Points, false)
+	}
+	return nil
+}
+
+// taskPosition returns the 1-based position of a task within its state
+func taskPosition(tx *sql.Tx, stateID int, rank string) (int, error) {
+	var position int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) + 1 FROM tasks WHERE state_id = $1 AND rank < $2", stateID, rank,
+	).Scan(&position)
+	return position, err
+}
+
+// bumpVersion marks a task as changed and returns its new version
+func bumpVersion(tx *sql.Tx, taskID int) (int, error) {
+	var version int
+	err := tx.QueryRow(
+		"UPDATE tasks SET version = version + 1 WHERE id = $1 RETURNING version", taskID,
+	).Scan(&version)
+	return version, err
+}
+
+// writeMoveError maps the errors of moving, checkWIP and setCompletion to a response
+func writeMoveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTransition), errors.Is(err, errWIPLimit):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errUnknownState), errors.Is(err, errUnknownTask):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errNotEnoughPoints):
+		http.Error(w, "Not enough points in pool to undo completion", http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update task: "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,376 @@
+package user
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"execute/internal"
+	"execute/internal/audit"
+	"execute/internal/handlers/auth"
+)
+
+// ExportTask is a task created by the exporting user
+type ExportTask struct {
+	ID           int        `json:"id"`
+	GroupID      int        `json:"groupId"`
+	CreationDate time.Time  `json:"creationDate"`
+	DueDate      *time.Time `json:"dueDate"`
+	DueDateOnly  bool       `json:"dueDateOnly"`
+	Priority     string     `json:"priority"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	PointsValue  int        `json:"pointsValue"`
+	Step         int        `json:"step"`
+	Completed    bool       `json:"completed"`
+}
+
+// ExportTaskEvent is an event of a task created by, or an action of, the exporting user
+type ExportTaskEvent struct {
+	ID        int    `json:"id"`
+	TaskID    int    `json:"taskId"`
+	ByYou     bool   `json:"byYou"`
+	EventType string `json:"eventType"`
+}
+
+type deleteAccountReq struct {
+	Tasks string `json:"tasks"` // "anonymise" (default) or "reassign"
+}
+
+type DeleteAccountResponse struct {
+	Message           string `json:"message"`
+	TasksAnonymised   int64  `json:"tasksAnonymised"`
+	TasksReassigned   int64  `json:"tasksReassigned"`
+	GroupsTransferred int    `json:"groupsTransferred"`
+	GroupsDeleted     int    `json:"groupsDeleted"`
+}
+
+// ExportHandler handles GET /user/export
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	// Collect everything before writing, so errors can still be reported
+	profile, err := GetUserProfile(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var avatar []byte
+	if err := internal.DB.QueryRow(
+		"SELECT avatar FROM users WHERE id = $1", userID,
+	).Scan(&avatar); err != nil {
+		http.Error(w, "Could not retrieve avatar: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tasks, err := exportTasks(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tasks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	events, err := exportTaskEvents(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch task events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Record(r, userID, audit.ActionAccountExport, map[string]any{
+		"tasks":      len(tasks),
+		"taskEvents": len(events),
+	})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="execute-export-`+time.Now().Format("2006-01-02")+`.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	writeJSON := func(name string, v any) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	if err := writeJSON("profile.json", profile); err != nil {
+		return
+	}
+	if err := writeJSON("tasks.json", tasks); err != nil {
+		return
+	}
+	if err := writeJSON("task_events.json", events); err != nil {
+		return
+	}
+	if len(avatar) > 0 {
+		name := "avatar.png"
+		if http.DetectContentType(avatar) == "image/jpeg" {
+			name = "avatar.jpg"
+		}
+		f, err := zw.Create(name)
+		if err != nil {
+			return
+		}
+		f.Write(avatar)
+	}
+}
+
+// exportTasks returns the tasks created by the user
+func exportTasks(userID int) ([]ExportTask, error) {
+	rows, err := internal.DB.Query(
+		`SELECT id, group_id, creation_date, due_date, due_date_only, priority, name, COALESCE(description, ''), points_value, step, completed
+		   FROM tasks
+		  WHERE creator_user_id = $1
+		  ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]ExportTask, 0)
+	for rows.Next() {
+		var t ExportTask
+		if err := rows.Scan(&t.ID, &t.GroupID, &t.CreationDate, &t.DueDate, &t.DueDateOnly, &t.Priority, &t.Name, &t.Description, &t.PointsValue, &t.Step, &t.Completed); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// exportTaskEvents returns the events of the user's tasks and the events the user caused.
+// Other users are not identified.
+func exportTaskEvents(userID int) ([]ExportTaskEvent, error) {
+	rows, err := internal.DB.Query(
+		`SELECT e.id, e.task_id, e.user_id IS NOT DISTINCT FROM $1, e.event_type
+		   FROM task_events e
+		  WHERE e.user_id = $1
+		     OR e.task_id IN (SELECT id FROM tasks WHERE creator_user_id = $1)
+		  ORDER BY e.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]ExportTaskEvent, 0)
+	for rows.Next() {
+		var e ExportTaskEvent
+		if err := rows.Scan(&e.ID, &e.TaskID, &e.ByYou, &e.EventType); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// DeleteAccountHandler handles DELETE /user
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	principal, err := auth.GetPrincipal(r)
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	userID := principal.UserID
+
+	if !auth.RequireElevated(w, r) {
+		return
+	}
+
+	req := deleteAccountReq{Tasks: "anonymise"}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Tasks != "anonymise" && req.Tasks != "reassign" {
+		http.Error(w, `tasks must be "anonymise" or "reassign"`, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := internal.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		http.Error(w, "Failed to lock account: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := DeleteAccountResponse{Message: "Account deleted"}
+
+	// Owned groups go to another member, empty groups are deleted
+	successors, deleted, err := releaseOwnedGroups(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to hand over groups: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.GroupsTransferred, resp.GroupsDeleted = len(successors), deleted
+
+	// Remaining tasks either go to the group owner or lose their author
+	if req.Tasks == "reassign" {
+		result, err := tx.Exec(
+			`UPDATE tasks t
+			    SET creator_user_id = m.user_id
+			   FROM group_memberships m
+			  WHERE m.group_id = t.group_id AND m.role = $1 AND t.creator_user_id = $2`,
+			auth.RoleOwner, userID,
+		)
+		if err != nil {
+			http.Error(w, "Failed to reassign tasks: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.TasksReassigned, _ = result.RowsAffected()
+	}
+	result, err := tx.Exec("UPDATE tasks SET creator_user_id = NULL WHERE creator_user_id = $1", userID)
+	if err != nil {
+		http.Error(w, "Failed to anonymise tasks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.TasksAnonymised, _ = result.RowsAffected()
+
+	if _, err := tx.Exec("UPDATE task_events SET user_id = NULL WHERE user_id = $1", userID); err != nil {
+		http.Error(w, "Failed to anonymise task events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := audit.RecordTx(tx, r, userID, audit.ActionAccountDelete, map[string]any{
+		"tasks":             req.Tasks,
+		"tasksAnonymised":   resp.TasksAnonymised,
+		"tasksReassigned":   resp.TasksReassigned,
+		"groupsTransferred": resp.GroupsTransferred,
+		"groupsDeleted":     resp.GroupsDeleted,
+	}); err != nil {
+		http.Error(w, "Failed to write audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Sessions, tokens, identities and the avatar go with the row
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+		http.Error(w, "Failed to delete account: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	for _, id := range successors {
+		auth.InvalidatePrincipal(id)
+	}
+
+	// Non-database session stores are not covered by the cascade
+	if err := auth.RevokeAllSessions(userID); err != nil {
+		http.Error(w, "Account deleted but sessions could not be revoked: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth.InvalidatePrincipal(userID)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// releaseOwnedGroups hands the groups owned by the user to another member,
+// or deletes them if the user is the last one. Groups the user created but no
+// longer owns are attributed to their current owner. The new owners are
+// returned so that their cached roles can be dropped after the commit.
+func releaseOwnedGroups(tx *sql.Tx, userID int) (successors []int, deleted int, err error) {
+	rows, err := tx.Query(
+		`SELECT group_id FROM group_memberships
+		  WHERE user_id = $1 AND role = $2
+		    FOR UPDATE`,
+		userID, auth.RoleOwner,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	var groupIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		groupIDs = append(groupIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for _, groupID := range groupIDs {
+		// Admins first, then the longest standing member
+		var successor int
+		err := tx.QueryRow(
+			`SELECT user_id FROM group_memberships
+			  WHERE group_id = $1 AND user_id <> $2
+			  ORDER BY CASE role WHEN 'admin' THEN 0 WHEN 'member' THEN 1 ELSE 2 END, joined_at, user_id
+			  LIMIT 1`,
+			groupID, userID,
+		).Scan(&successor)
+		if err == sql.ErrNoRows {
+			if err := internal.DeleteGroup(tx, groupID); err != nil {
+				return nil, 0, err
+			}
+			deleted++
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		// A group has one owner at a time, so the old membership goes first
+		if _, err := tx.Exec(
+			"DELETE FROM group_memberships WHERE group_id = $1 AND user_id = $2",
+			groupID, userID,
+		); err != nil {
+			return nil, 0, err
+		}
+		if _, err := tx.Exec(
+			"UPDATE group_memberships SET role = $1 WHERE group_id = $2 AND user_id = $3",
+			auth.RoleOwner, groupID, successor,
+		); err != nil {
+			return nil, 0, err
+		}
+		successors = append(successors, successor)
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE groups g
+		    SET creator_user_id = m.user_id
+		   FROM group_memberships m
+		  WHERE m.group_id = g.id AND m.role = $1 AND g.creator_user_id = $2`,
+		auth.RoleOwner, userID,
+	); err != nil {
+		return nil, 0, err
+	}
+	return successors, deleted, nil
+}
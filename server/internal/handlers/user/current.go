@@ -13,15 +13,18 @@ import (
 
 // UserProfile represents a user's profile with optional fields omitted when empty
 type UserProfile struct {
-	ID          int64  `json:"id"`
-	Username    string `json:"username"`
-	DisplayName string `json:"display_name,omitempty"`
-	Birthdate   string `json:"birthdate,omitempty"`
-	Phone       string `json:"phone,omitempty"`
-	Role        string `json:"role,omitempty"`
-	GroupID     int64  `json:"group_id,omitempty"`
-	CreatedAt   string `json:"created_at"`
-	UpdatedAt   string `json:"updated_at"`
+	ID            int64  `json:"id"`
+	Username      string `json:"username"`
+	DisplayName   string `json:"display_name,omitempty"`
+	Birthdate     string `json:"birthdate,omitempty"`
+	Phone         string `json:"phone,omitempty"`
+	Role          string `json:"role,omitempty"`
+	GroupID       int64  `json:"group_id,omitempty"`
+	Groups        []int  `json:"groups"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
 }
 
 // UserProfileHandler handles GET requests to fetch the current user profile
@@ -44,6 +47,10 @@ func UserProfileHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// The group this request acts on, which may differ from the first membership
+	if principal, err := auth.GetPrincipal(r); err == nil && principal.GroupID != 0 {
+		profile.GroupID = int64(principal.GroupID)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(profile)
@@ -53,19 +60,20 @@ func UserProfileHandler(w http.ResponseWriter, r *http.Request) {
 func GetUserProfile(userID int) (UserProfile, error) {
 	// Use sql.Null types to scan optional columns
 	type raw struct {
-		ID          int64          `db:"id"`
-		Username    string         `db:"username"`
-		DisplayName sql.NullString `db:"display_name"`
-		Birthdate   sql.NullTime   `db:"birth_date"`
-		Phone       sql.NullString `db:"phone"`
-		Role        sql.NullString `db:"role"`
-		GroupID     sql.NullInt64  `db:"group_id"`
-		CreatedAt   time.Time      `db:"created_at"`
-		UpdatedAt   time.Time      `db:"updated_at"`
+		ID              int64          `db:"id"`
+		Username        string         `db:"username"`
+		DisplayName     sql.NullString `db:"display_name"`
+		Birthdate       sql.NullTime   `db:"birth_date"`
+		Phone           sql.NullString `db:"phone"`
+		Role            sql.NullString `db:"role"`
+		Email           sql.NullString `db:"email"`
+		EmailVerifiedAt sql.NullTime   `db:"email_verified_at"`
+		CreatedAt       time.Time      `db:"created_at"`
+		UpdatedAt       time.Time      `db:"updated_at"`
 	}
 
 	query := `
-		SELECT id, username, display_name, birth_date, phone, role, group_id, created_at, updated_at
+		SELECT id, username, display_name, birth_date, phone, role, email, email_verified_at, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
@@ -78,7 +86,8 @@ func GetUserProfile(userID int) (UserProfile, error) {
 		&r.Birthdate,
 		&r.Phone,
 		&r.Role,
-		&r.GroupID,
+		&r.Email,
+		&r.EmailVerifiedAt,
 		&r.CreatedAt,
 		&r.UpdatedAt,
 	)
@@ -108,8 +117,35 @@ func GetUserProfile(userID int) (UserProfile, error) {
 	if r.Role.Valid {
 		profile.Role = r.Role.String
 	}
-	if r.GroupID.Valid {
-		profile.GroupID = r.GroupID.Int64
+	if r.Email.Valid {
+		profile.Email = r.Email.String
+	}
+	profile.EmailVerified = r.EmailVerifiedAt.Valid
+
+	// Memberships, oldest first; the first one is the default group
+	rows, err := internal.DB.Query(
+		`SELECT group_id FROM group_memberships
+		  WHERE user_id = $1
+		  ORDER BY joined_at, group_id`,
+		userID,
+	)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	defer rows.Close()
+	profile.Groups = make([]int, 0)
+	for rows.Next() {
+		var groupID int
+		if err := rows.Scan(&groupID); err != nil {
+			return UserProfile{}, err
+		}
+		profile.Groups = append(profile.Groups, groupID)
+	}
+	if err := rows.Err(); err != nil {
+		return UserProfile{}, err
+	}
+	if len(profile.Groups) > 0 {
+		profile.GroupID = int64(profile.Groups[0])
 	}
 
 	return profile, nil
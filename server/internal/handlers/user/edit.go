@@ -54,28 +54,13 @@ func handleJSONUpdate(w http.ResponseWriter, r *http.Request, userID int) {
 		return
 	}
 
-	if req.Password == nil {
-		http.Error(w, "Current password is required", http.StatusBadRequest)
+	// The current password may be sent along as a fresh authentication
+	if req.Password != nil && !auth.ConfirmPassword(w, r, *req.Password) {
 		return
 	}
 
-	// Retrieve the user's stored password hash and salt from the database
-	var storedHash, storedSaltStr string
-	if err := internal.DB.QueryRow(
-		"SELECT passwordhash, salt FROM users WHERE id=$1", userID,
-	).Scan(&storedHash, &storedSaltStr); err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	// Convert storedSalt (string) to []byte before passing it to HashPassword
-	saltBytes, err := auth.DecodeSalt(storedSaltStr)
-	if err != nil {
-		http.Error(w, "Server error decoding salt", http.StatusInternalServerError)
-		return
-	}
-	if auth.HashPassword(*req.Password, saltBytes) != storedHash {
-		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+	// Username, password and avatar changes need a recently authenticated session
+	if (req.Username != nil || req.NewPassword != nil || req.Avatar != nil) && !auth.RequireElevated(w, r) {
 		return
 	}
 
@@ -95,16 +80,14 @@ func handleJSONUpdate(w http.ResponseWriter, r *http.Request, userID int) {
 			http.Error(w, "New password is too short", http.StatusBadRequest)
 			return
 		}
-		newSalt, err := auth.GenerateSalt()
+		hash, err := auth.HashPassword(*req.NewPassword)
 		if err != nil {
-			http.Error(w, "Failed to generate salt", http.StatusInternalServerError)
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 			return
 		}
-		updates = append(updates, "salt = $"+strconv.Itoa(argPos))
-		args = append(args, auth.EncodeSalt(newSalt))
-		argPos++
+		updates = append(updates, "salt = ''")
 		updates = append(updates, "passwordhash = $"+strconv.Itoa(argPos))
-		args = append(args, auth.HashPassword(*req.NewPassword, newSalt))
+		args = append(args, hash)
 		argPos++
 	}
 
@@ -171,6 +154,15 @@ func handleJSONUpdate(w http.ResponseWriter, r *http.Request, userID int) {
 		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	auth.InvalidatePrincipal(userID)
+
+	// A password change signs out every other device
+	if req.NewPassword != nil {
+		if _, err := auth.RevokeOtherSessions(r, userID); err != nil {
+			http.Error(w, "Failed to revoke other sessions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -207,6 +199,11 @@ func handleMultipartUpdate(w http.ResponseWriter, r *http.Request, userID int) {
 		}
 	}
 
+	// Username, password and avatar changes need a recently authenticated session
+	if (username != "" || password != "" || avatarBytes != nil) && !auth.RequireElevated(w, r) {
+		return
+	}
+
 	var updates []string
 	var args []any
 	argPos := 1
@@ -217,15 +214,12 @@ func handleMultipartUpdate(w http.ResponseWriter, r *http.Request, userID int) {
 		argPos++
 	}
 	if password != "" {
-		salt, err := auth.GenerateSalt()
+		hash, err := auth.HashPassword(password)
 		if err != nil {
-			http.Error(w, "Failed to generate salt", http.StatusInternalServerError)
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 			return
 		}
-		hash := auth.HashPassword(password, salt)
-		updates = append(updates, "salt = $"+strconv.Itoa(argPos))
-		args = append(args, auth.EncodeSalt(salt))
-		argPos++
+		updates = append(updates, "salt = ''")
 		updates = append(updates, "passwordhash = $"+strconv.Itoa(argPos))
 		args = append(args, hash)
 		argPos++
@@ -272,6 +266,15 @@ func handleMultipartUpdate(w http.ResponseWriter, r *http.Request, userID int) {
 		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	auth.InvalidatePrincipal(userID)
+
+	// A password change signs out every other device
+	if password != "" {
+		if _, err := auth.RevokeOtherSessions(r, userID); err != nil {
+			http.Error(w, "Failed to revoke other sessions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,67 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"execute/internal"
+	"execute/internal/handlers/auth"
+)
+
+// SecurityOverview summarises the security state of the current user's account
+type SecurityOverview struct {
+	FailedLoginAttempts int        `json:"failedLoginAttempts"`
+	FailedLoginsTotal   int        `json:"failedLoginsTotal"`
+	LastFailedLoginAt   *time.Time `json:"lastFailedLoginAt,omitempty"`
+	LockedUntil         *time.Time `json:"lockedUntil,omitempty"`
+	TwoFactorEnabled    bool       `json:"twoFactorEnabled"`
+}
+
+// SecurityHandler handles GET /user/security
+func SecurityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserID(r)
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var overview SecurityOverview
+	var lastFailed, lockedUntil sql.NullTime
+	err = internal.DB.QueryRow(
+		`SELECT failed_login_count, failed_login_total, last_failed_login_at, locked_until, totp_enabled
+		   FROM users
+		  WHERE id = $1`,
+		userID,
+	).Scan(
+		&overview.FailedLoginAttempts,
+		&overview.FailedLoginsTotal,
+		&lastFailed,
+		&lockedUntil,
+		&overview.TwoFactorEnabled,
+	)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load security overview: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if lastFailed.Valid {
+		overview.LastFailedLoginAt = &lastFailed.Time
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		overview.LockedUntil = &lockedUntil.Time
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(overview)
+}
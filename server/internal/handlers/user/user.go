@@ -8,20 +8,23 @@ import (
 	"execute/internal"
 )
 
-// GetUserGroupID looks up the group ID associated with a user by their user ID
+// GetUserGroupID looks up the group a user joined first by their user ID
 func GetUserGroupID(userID int) (int, error) {
-	var groupID sql.NullInt32
+	var groupID int
 	err := internal.DB.QueryRow(
-		`SELECT group_id FROM users WHERE id = $1`,
+		`SELECT group_id FROM group_memberships
+		  WHERE user_id = $1
+		  ORDER BY joined_at, group_id
+		  LIMIT 1`,
 		userID,
 	).Scan(&groupID)
-	if err == sql.ErrNoRows || !groupID.Valid {
+	if err == sql.ErrNoRows {
 		return 0, errors.New("no group associated with user")
 	}
 	if err != nil {
 		return 0, err
 	}
-	return int(groupID.Int32), nil
+	return groupID, nil
 }
 
 // GetUserDisplayName looks up the display name associated with a user by their user ID
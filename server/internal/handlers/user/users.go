@@ -21,6 +21,7 @@ type GroupMember struct {
 	ID          int        `json:"id"`
 	Username    string     `json:"username"`
 	Role        *string    `json:"role,omitempty"`
+	GroupRole   string     `json:"groupRole"`
 	DisplayName *string    `json:"display_name,omitempty"`
 	Phone       *string    `json:"phone,omitempty"`
 	BirthDate   *time.Time `json:"birth_date,omitempty"`
@@ -62,22 +63,24 @@ func UsersHandler(w http.ResponseWriter, r *http.Request) {
 
 // GroupUsersHanlder handles the /group GET endpoint
 func GroupUsersHanlder(w http.ResponseWriter, r *http.Request) {
-	userID, err := auth.GetUserID(r)
+	principal, err := auth.GetPrincipal(r)
 	if err != nil {
 		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	groupID, err := GetUserGroupID(userID)
+	groupID, err := principal.Group()
 	if err != nil {
 		http.Error(w, "You are not in a group: "+err.Error(), http.StatusForbidden)
 		return
 	}
 
 	rows, err := internal.DB.Query(`
-    SELECT id, username, role, display_name, phone, birth_date
-      FROM users
-     WHERE group_id = $1
+    SELECT u.id, u.username, m.role, u.role, u.display_name, u.phone, u.birth_date
+      FROM group_memberships m
+      JOIN users u ON u.id = m.user_id
+     WHERE m.group_id = $1
+     ORDER BY m.joined_at, u.id
   `, groupID)
 	if err != nil {
 		http.Error(w, "Failed to query group members: "+err.Error(), http.StatusInternalServerError)
@@ -94,6 +97,7 @@ func GroupUsersHanlder(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(
 			&m.ID,
 			&m.Username,
+			&m.GroupRole,
 			&r,
 			&dn,
 			&ph,
@@ -0,0 +1,111 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var mailer Mailer
+
+// Init selects the mailer from the MAIL_DRIVER environment variable
+func Init() {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Execute <no-reply@execute.local>"
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		port := 25
+		if value := os.Getenv("SMTP_PORT"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				log.Fatalf("invalid SMTP_PORT %q: %v", value, err)
+			}
+			port = parsed
+		}
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			log.Fatal("MAIL_DRIVER=smtp requires SMTP_HOST")
+		}
+		mailer = &SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+		log.Printf("sending mail via SMTP %s:%d", host, port)
+	case "", "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		mailer = &FileMailer{Dir: dir, From: from}
+		log.Printf("writing mail to %s", dir)
+	default:
+		log.Fatalf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// Send delivers a message with the configured mailer
+func Send(msg Message) error {
+	if mailer == nil {
+		return fmt.Errorf("mailer not initialised")
+	}
+	return mailer.Send(msg)
+}
+
+// AppURL returns an absolute link into the web app, based on APP_URL
+func AppURL(path string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+// render encodes a message in RFC 5322 format
+func render(from string, msg Message) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "execute.local"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer sends messages through an SMTP server. STARTTLS is used when the
+// server offers it; credentials are optional so local catchers work as well.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	sender, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	data, err := render(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := m.Host + ":" + strconv.Itoa(m.Port)
+	if err := smtp.SendMail(addr, auth, sender.Address, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
@@ -1,14 +1,13 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
-	"strings"
 	"sync"
 
 	"golang.org/x/time/rate"
 
 	"execute/internal/handlers/auth"
+	"execute/internal/utils"
 )
 
 // 60 requests for 30 minutes
@@ -44,31 +43,11 @@ func (i *IPRateLimiter) getLimiter(ip string) *rate.Limiter {
 	return limiter
 }
 
-// getIP extracts the client's real IP address from the request
-// It first checks the X-Forwarded-For header and falls back to RemoteAddr
-func getIP(r *http.Request) string {
-	// If behind a proxy, the real IP might be in the X-Forwarded-For header
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		parts := strings.Split(xff, ",")
-		ip := strings.TrimSpace(parts[0])
-		if net.ParseIP(ip) != nil {
-			return ip
-		}
-	}
-	// If no X-Forwarded-For header, use RemoteAddr (strip the port if present)
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
-}
-
 // rateLimitMiddleware is a middleware that checks if the request
 // from an IP is allowed to proceed
 func rateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getIP(r)
+		ip := utils.GetIP(r)
 		limiter := limiterStore.getLimiter(ip)
 		if !limiter.Allow() {
 			http.Error(w, "Too many requests", http.StatusTooManyRequests)
@@ -91,27 +70,44 @@ func ApplyMiddlewares(handler http.Handler) http.Handler {
 	return rateLimitMiddleware(setContentTypeMiddleware(handler))
 }
 
-// authMiddleware is a middleware that checks for a valid session cookie.
-func authMiddleware(next http.Handler) http.Handler {
+// Scopes maps an HTTP method to the access token scope a route requires for it
+type Scopes map[string]string
+
+// authMiddleware is a middleware that checks for a valid session cookie or
+// personal access token and stores the authenticated principal in the context. Access tokens are only accepted for methods listed in
+// scopes and only if they were granted the matching scope.
+func authMiddleware(next http.Handler, scopes Scopes) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
+		principal, err := auth.ResolvePrincipal(r)
 		if err != nil {
 			http.NotFound(w, r)
 			return
 		}
-		username, exists := auth.GetSessionUsername(cookie.Value)
-		if !exists || strings.TrimSpace(username) == "" {
-			http.NotFound(w, r)
-			return
+		if principal.TokenID != 0 {
+			scope, ok := scopes[r.Method]
+			if !ok {
+				http.Error(w, "This endpoint cannot be used with an access token", http.StatusForbidden)
+				return
+			}
+			if !principal.HasScope(scope) {
+				http.Error(w, "Access token is missing the "+scope+" scope", http.StatusForbidden)
+				return
+			}
 		}
-		// You can set the username in the request context here if needed.
-		next.ServeHTTP(w, r)
+		// Handlers read the resolved principal from the request context
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
 	})
 }
 
-// Helper function to apply multiple middlewares to a handler
+// Helper function to apply multiple middlewares to a handler that only accepts session cookies
 func ApplyAuthMiddlewares(handler http.Handler) http.Handler {
-	return rateLimitMiddleware(authMiddleware(setContentTypeMiddleware(handler)))
+	return rateLimitMiddleware(authMiddleware(setContentTypeMiddleware(handler), nil))
+}
+
+// Helper function to apply multiple middlewares to a handler that also accepts
+// personal access tokens carrying the scope required for the request method
+func ApplyScopedAuthMiddlewares(handler http.Handler, scopes Scopes) http.Handler {
+	return rateLimitMiddleware(authMiddleware(setContentTypeMiddleware(handler), scopes))
 }
 
 // corsMiddleware sets CORS headers to allow corss-origin requests
@@ -119,7 +115,7 @@ func CorsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Group-ID")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == http.MethodOptions {
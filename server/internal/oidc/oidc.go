@@ -0,0 +1,293 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes the client registration at an OpenID provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients
+	RedirectURL  string
+	Scopes       []string
+	// AuthURL overrides the discovered authorization endpoint. Useful when the
+	// browser reaches the provider under a different host than the server.
+	AuthURL string
+}
+
+// Claims are the ID token claims used for login
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// audience accepts both forms of the aud claim, a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is a discovered OpenID provider
+type Provider struct {
+	config    Config
+	discovery discovery
+	keys      *keySet
+	client    *http.Client
+}
+
+// clockSkew is the tolerance for exp and iat checks
+const clockSkew = time.Minute
+
+// Discover loads the provider metadata from the issuer's well-known endpoint
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	wellKnown := strings.TrimRight(config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var d discovery
+	if err := getJSON(ctx, client, wellKnown, &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if d.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery returned issuer %q, expected %q", d.Issuer, config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document is missing endpoints")
+	}
+	if config.AuthURL != "" {
+		d.AuthorizationEndpoint = config.AuthURL
+	}
+
+	return &Provider{
+		config:    config,
+		discovery: d,
+		keys:      &keySet{uri: d.JWKSURI, client: client},
+		client:    client,
+	}, nil
+}
+
+// Issuer returns the issuer identifier of the provider
+func (p *Provider) Issuer() string {
+	return p.discovery.Issuer
+}
+
+// AuthCodeURL returns the URL the browser is sent to for login
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Exchange redeems an authorization code and returns the raw ID token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s: %s", resp.Status, body)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token response contains no id_token")
+	}
+	return token.IDToken, nil
+}
+
+// VerifyIDToken checks the signature and the standard claims of an ID token
+// and that it was issued for the given nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	payload, err := p.keys.verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid id token claims: %w", err)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.discovery.Issuer:
+		return nil, fmt.Errorf("id token issued by %q", claims.Issuer)
+	case !slices.Contains(claims.Audience, p.config.ClientID):
+		return nil, errors.New("id token not issued for this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, errors.New("id token authorized party mismatch")
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return nil, errors.New("id token expired")
+	case claims.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, errors.New("id token issued in the future")
+	case claims.Nonce == "" || claims.Nonce != nonce:
+		return nil, errors.New("id token nonce mismatch")
+	case claims.Subject == "":
+		return nil, errors.New("id token has no subject")
+	}
+	return &claims, nil
+}
+
+// RandomString returns a URL-safe random string for state, nonce and PKCE verifiers
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge from a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// keySet caches the provider's signing keys and refreshes them when a token
+// refers to an unknown key ID
+type keySet struct {
+	uri       string
+	client    *http.Client
+	keys      map[string]any
+	fetchedAt time.Time
+	mu        sync.Mutex
+}
+
+// keyRefreshInterval limits how often unknown key IDs trigger a JWKS download
+const keyRefreshInterval = time.Minute
+
+func (s *keySet) key(ctx context.Context, kid string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	if time.Since(s.fetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := fetchKeys(ctx, s.client, s.uri)
+	if err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds a cached key. Providers with a single key may omit the kid.
+func (s *keySet) lookup(kid string) (any, bool) {
+	if key, ok := s.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
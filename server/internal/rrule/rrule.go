@@ -0,0 +1,420 @@
+// Package rrule parses and expands the recurrence rules of RFC 5545.
+//
+// Only the parts needed for meeting schedules are supported: FREQ (DAILY,
+// WEEKLY, MONTHLY, YEARLY), INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY,
+// BYMONTH and WKST. Other parts are rejected, so a rule is never silently
+// expanded differently than a calendar application would.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a rule
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// maxPeriods bounds the expansion of rules whose filters rarely match
+const maxPeriods = 100000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// Day is a BYDAY entry. N is the ordinal within the month, 1 for the first,
+// -1 for the last, 0 for every such weekday.
+type Day struct {
+	Weekday time.Weekday
+	N       int
+}
+
+// Rule is a parsed RRULE
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int       // 0 means no limit
+	Until      time.Time // Zero means no limit
+	ByDay      []Day
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;BYDAY=TU,TH". A leading "RRULE:" is allowed.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("rrule is empty")
+	}
+
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(name)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("rrule part %q is not NAME=VALUE", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("rrule part %s is repeated", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(value))
+			if r.Freq != Daily && r.Freq != Weekly && r.Freq != Monthly && r.Freq != Yearly {
+				err = fmt.Errorf("unsupported FREQ %s", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = positive(name, value)
+		case "COUNT":
+			r.Count, err = positive(name, value)
+		case "UNTIL":
+			r.Until, err = parseUntil(value)
+		case "BYDAY":
+			r.ByDay, err = parseDays(value)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(name, value, 1, 31, true)
+		case "BYMONTH":
+			var months []int
+			months, err = parseInts(name, value, 1, 12, false)
+			for _, m := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "WKST":
+			wd, ok := weekdays[strings.ToUpper(value)]
+			if !ok {
+				err = fmt.Errorf("invalid WKST %s", value)
+			}
+			r.WeekStart = wd
+		default:
+			err = fmt.Errorf("unsupported rrule part %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, errors.New("rrule needs a FREQ")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, errors.New("rrule cannot have both COUNT and UNTIL")
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly && !(r.Freq == Yearly && len(r.ByMonth) > 0) {
+			return nil, errors.New("numbered BYDAY entries are only supported with FREQ=MONTHLY, or FREQ=YEARLY with BYMONTH")
+		}
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return nil, errors.New("BYMONTHDAY is not allowed with FREQ=WEEKLY")
+	}
+	return r, nil
+}
+
+func positive(name, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	return n, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if layout == "20060102" {
+				// A date includes the whole day
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid UNTIL %s", value)
+}
+
+func parseDays(value string) ([]Day, error) {
+	var days []Day
+	for _, item := range strings.Split(strings.ToUpper(value), ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("invalid BYDAY %s", item)
+		}
+		wd, ok := weekdays[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("invalid BYDAY %s", item)
+		}
+		d := Day{Weekday: wd}
+		if prefix := item[:len(item)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("invalid BYDAY %s", item)
+			}
+			d.N = n
+		}
+		days = append(days, d)
+	}
+	return days, nil
+}
+
+func parseInts(name, value string, min, max int, negative bool) ([]int, error) {
+	var out []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		abs := n
+		if negative && n < 0 {
+			abs = -n
+		}
+		if err != nil || abs < min || abs > max || (!negative && n < 0) {
+			return nil, fmt.Errorf("invalid %s %s", name, item)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// String formats the rule in RFC 5545 syntax, without the "RRULE:" prefix
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	if len(r.ByDay) > 0 {
+		var days []string
+		for _, d := range r.ByDay {
+			code := strings.ToUpper(d.Weekday.String()[:2])
+			if d.N != 0 {
+				code = strconv.Itoa(d.N) + code
+			}
+			days = append(days, code)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		var days []string
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonth) > 0 {
+		var months []string
+		for _, m := range r.ByMonth {
+			months = append(months, strconv.Itoa(int(m)))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+strings.ToUpper(r.WeekStart.String()[:2]))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Between returns the occurrences of the rule starting at dtstart that fall
+// in [from, to), at most limit of them. The wall clock time of dtstart is kept
+// in dtstart's location, so occurrences follow daylight saving changes.
+// COUNT is counted from dtstart, not from from.
+func (r *Rule) Between(dtstart, from, to time.Time, limit int) []time.Time {
+	var out []time.Time
+	seen := 0
+	for k := 0; k < maxPeriods; k++ {
+		start, candidates := r.period(dtstart, k*r.Interval)
+		if !start.Before(to) || (!r.Until.IsZero() && start.After(r.Until)) {
+			return out
+		}
+		for _, t := range candidates {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return out
+			}
+			if !t.Before(to) {
+				return out
+			}
+			seen++
+			if r.Count > 0 && seen > r.Count {
+				return out
+			}
+			if !t.Before(from) {
+				out = append(out, t)
+				if limit > 0 && len(out) >= limit {
+					return out
+				}
+			}
+		}
+	}
+	return out
+}
+
+// period returns the start and the sorted candidates of the period offset
+// periods after dtstart's
+func (r *Rule) period(dtstart time.Time, offset int) (time.Time, []time.Time) {
+	loc := dtstart.Location()
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hh, mm, ss, 0, loc)
+	}
+
+	var start time.Time
+	var out []time.Time
+	switch r.Freq {
+	case Daily:
+		day := at(y, m, d+offset)
+		start = day
+		if r.matchMonth(day.Month()) && r.matchMonthDay(day) && r.matchWeekday(day) {
+			out = append(out, day)
+		}
+	case Weekly:
+		// Start of dtstart's week, by WKST
+		back := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		first := at(y, m, d-back+7*offset)
+		start = first
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []Day{{Weekday: dtstart.Weekday()}}
+		}
+		for _, wd := range days {
+			shift := (int(wd.Weekday) - int(r.WeekStart) + 7) % 7
+			fy, fm, fd := first.Date()
+			day := at(fy, fm, fd+shift)
+			if r.matchMonth(day.Month()) {
+				out = append(out, day)
+			}
+		}
+	case Monthly:
+		month := at(y, m+time.Month(offset), 1)
+		start = month
+		if r.matchMonth(month.Month()) {
+			out = r.inMonth(month.Year(), month.Month(), d, at)
+		}
+	case Yearly:
+		start = at(y+offset, time.January, 1)
+		months := r.ByMonth
+		if len(months) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+			months = []time.Month{m}
+		} else if len(months) == 0 {
+			// The filters apply to every month of the year
+			for month := time.January; month <= time.December; month++ {
+				months = append(months, month)
+			}
+		}
+		for _, month := range months {
+			out = append(out, r.inMonth(y+offset, month, d, at)...)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return start, slices.CompactFunc(out, func(a, b time.Time) bool { return a.Equal(b) })
+}
+
+// inMonth expands BYMONTHDAY and BYDAY within one month. Without either, the
+// day of month of dtstart is used and months without that day are skipped.
+func (r *Rule) inMonth(y int, m time.Month, dtstartDay int, at func(int, time.Month, int) time.Time) []time.Time {
+	last := time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	var days []int
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, md := range r.ByMonthDay {
+			if md < 0 {
+				md = last + md + 1
+			}
+			if md >= 1 && md <= last {
+				days = append(days, md)
+			}
+		}
+	case len(r.ByDay) == 0:
+		if dtstartDay <= last {
+			days = append(days, dtstartDay)
+		}
+	}
+
+	if len(r.ByDay) > 0 {
+		var byDay []int
+		for _, wd := range r.ByDay {
+			byDay = append(byDay, weekdayDays(y, m, last, wd)...)
+		}
+		if len(r.ByMonthDay) > 0 {
+			// Both are given, BYDAY limits the month days
+			days = slices.DeleteFunc(days, func(d int) bool { return !slices.Contains(byDay, d) })
+		} else {
+			days = byDay
+		}
+	}
+
+	var out []time.Time
+	for _, d := range days {
+		out = append(out, at(y, m, d))
+	}
+	return out
+}
+
+// weekdayDays returns the days of the month that match a BYDAY entry
+func weekdayDays(y int, m time.Month, last int, wd Day) []int {
+	first := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC).Weekday()
+	start := 1 + (int(wd.Weekday)-int(first)+7)%7
+	var days []int
+	for d := start; d <= last; d += 7 {
+		days = append(days, d)
+	}
+	switch {
+	case wd.N > 0 && wd.N <= len(days):
+		return []int{days[wd.N-1]}
+	case wd.N < 0 && -wd.N <= len(days):
+		return []int{days[len(days)+wd.N]}
+	case wd.N != 0:
+		return nil
+	}
+	return days
+}
+
+func (r *Rule) matchMonth(m time.Month) bool {
+	return len(r.ByMonth) == 0 || slices.Contains(r.ByMonth, m)
+}
+
+func (r *Rule) matchMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, md := range r.ByMonthDay {
+		if md == t.Day() || last+md+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if d.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package rrule
+
+import (
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Berlin without the system's zoneinfo
+)
+
+func TestBetween(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		rule     string
+		dtstart  time.Time
+		from, to time.Time
+		limit    int
+		want     []string // RFC 3339 in dtstart's location
+	}{
+		{
+			name:    "weekly on two days with COUNT",
+			rule:    "FREQ=WEEKLY;BYDAY=TU,TH;COUNT=5",
+			dtstart: utc("2025-03-04T10:00:00Z"),
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want: []string{
+				"2025-03-04T10:00:00Z", "2025-03-06T10:00:00Z", "2025-03-11T10:00:00Z",
+				"2025-03-13T10:00:00Z", "2025-03-18T10:00:00Z",
+			},
+		},
+		{
+			name:    "weekly starting between its days",
+			rule:    "FREQ=WEEKLY;BYDAY=MO,FR;COUNT=3",
+			dtstart: utc("2025-03-05T10:00:00Z"), // A Wednesday
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want:    []string{"2025-03-07T10:00:00Z", "2025-03-10T10:00:00Z", "2025-03-14T10:00:00Z"},
+		},
+		{
+			name:    "monthly on the last Friday",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR;COUNT=4",
+			dtstart: utc("2025-01-31T18:00:00Z"),
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want: []string{
+				"2025-01-31T18:00:00Z", "2025-02-28T18:00:00Z", "2025-03-28T18:00:00Z", "2025-04-25T18:00:00Z",
+			},
+		},
+		{
+			name:    "monthly on the 31st skips short months",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=31;COUNT=4",
+			dtstart: utc("2025-01-31T09:00:00Z"),
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want: []string{
+				"2025-01-31T09:00:00Z", "2025-03-31T09:00:00Z", "2025-05-31T09:00:00Z", "2025-07-31T09:00:00Z",
+			},
+		},
+		{
+			name:    "monthly without BYMONTHDAY skips months without the day",
+			rule:    "FREQ=MONTHLY;COUNT=3",
+			dtstart: utc("2025-01-30T09:00:00Z"),
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want:    []string{"2025-01-30T09:00:00Z", "2025-03-30T09:00:00Z", "2025-04-30T09:00:00Z"},
+		},
+		{
+			name:    "window after the first occurrences keeps COUNT from dtstart",
+			rule:    "FREQ=DAILY;COUNT=10",
+			dtstart: utc("2025-05-01T08:00:00Z"),
+			from:    utc("2025-05-08T00:00:00Z"),
+			to:      utc("2025-06-01T00:00:00Z"),
+			want:    []string{"2025-05-08T08:00:00Z", "2025-05-09T08:00:00Z", "2025-05-10T08:00:00Z"},
+		},
+		{
+			name:    "window after the first occurrences with INTERVAL",
+			rule:    "FREQ=WEEKLY;INTERVAL=2",
+			dtstart: utc("2025-01-06T12:00:00Z"),
+			from:    utc("2025-03-01T00:00:00Z"),
+			to:      utc("2025-04-01T00:00:00Z"),
+			want:    []string{"2025-03-03T12:00:00Z", "2025-03-17T12:00:00Z", "2025-03-31T12:00:00Z"},
+		},
+		{
+			name:    "window end is exclusive",
+			rule:    "FREQ=DAILY",
+			dtstart: utc("2025-05-01T08:00:00Z"),
+			from:    utc("2025-05-01T00:00:00Z"),
+			to:      utc("2025-05-03T08:00:00Z"),
+			want:    []string{"2025-05-01T08:00:00Z", "2025-05-02T08:00:00Z"},
+		},
+		{
+			name:    "limit",
+			rule:    "FREQ=DAILY",
+			dtstart: utc("2025-05-01T08:00:00Z"),
+			from:    utc("2025-05-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			limit:   2,
+			want:    []string{"2025-05-01T08:00:00Z", "2025-05-02T08:00:00Z"},
+		},
+		{
+			name:    "UNTIL as a date includes that day",
+			rule:    "FREQ=DAILY;UNTIL=20250503",
+			dtstart: utc("2025-05-01T20:00:00Z"),
+			from:    utc("2025-05-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want:    []string{"2025-05-01T20:00:00Z", "2025-05-02T20:00:00Z", "2025-05-03T20:00:00Z"},
+		},
+		{
+			name:    "yearly in the given month",
+			rule:    "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH;COUNT=2",
+			dtstart: utc("2025-11-27T17:00:00Z"),
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2030-01-01T00:00:00Z"),
+			want:    []string{"2025-11-27T17:00:00Z", "2026-11-26T17:00:00Z"},
+		},
+		{
+			name:    "wall clock time is kept across the spring DST change",
+			rule:    "FREQ=WEEKLY;COUNT=3",
+			dtstart: time.Date(2025, time.March, 20, 9, 0, 0, 0, berlin),
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want:    []string{"2025-03-20T09:00:00+01:00", "2025-03-27T09:00:00+01:00", "2025-04-03T09:00:00+02:00"},
+		},
+		{
+			name:    "wall clock time is kept across the autumn DST change",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: time.Date(2025, time.October, 25, 18, 30, 0, 0, berlin),
+			from:    utc("2025-01-01T00:00:00Z"),
+			to:      utc("2026-01-01T00:00:00Z"),
+			want:    []string{"2025-10-25T18:30:00+02:00", "2025-10-26T18:30:00+01:00", "2025-10-27T18:30:00+01:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rule, err)
+			}
+			var got []string
+			for _, o := range r.Between(tt.dtstart, tt.from, tt.to, tt.limit) {
+				got = append(got, o.Format(time.RFC3339))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Between = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string // Empty if the rule is invalid
+	}{
+		{"RRULE:FREQ=WEEKLY;BYDAY=TU,TH", "FREQ=WEEKLY;BYDAY=TU,TH"},
+		{"freq=monthly;byday=-1fr;count=4", "FREQ=MONTHLY;COUNT=4;BYDAY=-1FR"},
+		{"FREQ=DAILY;INTERVAL=2;UNTIL=20250601T100000Z;WKST=SU", "FREQ=DAILY;INTERVAL=2;UNTIL=20250601T100000Z;WKST=SU"},
+		{"", ""},
+		{"BYDAY=MO", ""},
+		{"FREQ=HOURLY", ""},
+		{"FREQ=DAILY;COUNT=0", ""},
+		{"FREQ=DAILY;COUNT=3;UNTIL=20250601", ""},
+		{"FREQ=DAILY;FREQ=WEEKLY", ""},
+		{"FREQ=WEEKLY;BYDAY=1MO", ""},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", ""},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", ""},
+		{"FREQ=MONTHLY;BYSETPOS=1", ""},
+	}
+
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want an error", tt.rule, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+		} else if got := r.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// GetIP extracts the client's real IP address from the request
+// It first checks the X-Forwarded-For header and falls back to RemoteAddr
+func GetIP(r *http.Request) string {
+	// If behind a proxy, the real IP might be in the X-Forwarded-For header
+	xff := r.Header.Get("X-Forwarded-For")
+	if xff != "" {
+		parts := strings.Split(xff, ",")
+		ip := strings.TrimSpace(parts[0])
+		if net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	// If no X-Forwarded-For header, use RemoteAddr (strip the port if present)
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
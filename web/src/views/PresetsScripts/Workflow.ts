@@ -0,0 +1,34 @@
+import { ref } from 'vue'
+
+export interface WorkflowState {
+    id: number,
+    name: string,
+    color: string,
+    position: number,
+    wipLimit: number | null,
+    done: boolean,
+}
+
+export const workflowStates = ref<WorkflowState[]>([])
+
+export async function fetchWorkflow() {
+  try {
+    const response = await fetch('api/v1/group/workflow', {
+      method: 'GET',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      credentials: 'include',
+    });
+
+    if (response.ok) {
+      const data = await response.json();
+      //Board columns in order
+      workflowStates.value = Array.isArray(data.states) ? data.states : [];
+    } else {
+      console.error('Error fetching workflow:', response.status);
+    }
+  } catch (error) {
+    console.error('Failed to fetch workflow', error);
+  }
+}
@@ -1,29 +1,53 @@
 import { fetchTeamInfo } from "./GroupInfo";
 
 
-export async function onDragChange(event, newStepId) {
-    //if no tasks - exit
-    if (!event.added) return;
+export async function onDragChange(event, stateId, refresh) {
+    //Dropped from another column, or reordered within this one
+    const change = event.added || event.moved;
+    if (!change) return;
     //what task was dropped
-    const task = event.added.element;
-    //Current task step
-    const currentStep = task.step;
-    //If task is not moved - exit 
-    if (currentStep === newStepId) return;
-    //Calculate step
-    const stepChange = newStepId - currentStep;
-    //Forward / Backwards
-    const action = stepChange > 0 ? "+1" : "-1";
+    const task = change.element;
 
-    //Loop number of steps to move
-    for (let i = 0; i < Math.abs(stepChange); i++) {
-        const success = await updateTaskStep(task.id, action); //Move task call updateTaskStep
-        if (!success) break; //If failed, stop 
-        task.step += action === "+1" ? 1 : -1; //Update local after each update
-    }
+    //One move to the column and place it was dropped at
+    const success = await moveTask(task, stateId, change.newIndex + 1);
+    if (success) return;
+    //Stale or rejected move - reload the board
+    if (refresh) await refresh();
 }
 
-
+export async function moveTask(task, stateId, position) {
+    try {
+        const response = await fetch('api/v1/task', {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            credentials: 'include',
+            body: JSON.stringify({
+                taskId: task.id,
+                stateId: stateId,
+                position: position,
+                version: task.version
+            })
+        });
+        if (response.ok) {
+            const moved = await response.json();
+            //Update local so the next move is based on this version
+            task.stateId = moved.stateId;
+            task.step = moved.step;
+            task.completed = moved.completed;
+            task.version = moved.version;
+            await fetchTeamInfo();
+            return true;
+        } else {
+            console.error('Failed to move task:', response.status)
+            return false;
+        }
+    } catch (error) {
+        console.error('Error moving task', error);
+        return false;
+    }
+}
 
 export async function updateTaskStep(taskId, action) {
     try {
@@ -26,6 +26,7 @@ export async function toggleCompletion(task) {
       await fetchTeamInfo();
       const result = await response.json();
       task.completed = result.completed;
+      task.version = result.version;
     } catch (error) {
       console.error('Error updating task completion:', error);
     }